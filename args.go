@@ -0,0 +1,68 @@
+package vcsstate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checkArg returns an error if arg, a value of the given kind (such as "revision")
+// that's passed to a version control system binary, could be interpreted
+// as a command-line option, or is otherwise not a valid argument.
+func checkArg(kind, arg string) error {
+	switch {
+	case arg == "":
+		return fmt.Errorf("invalid %s: empty", kind)
+	case strings.HasPrefix(arg, "-"):
+		return fmt.Errorf("invalid %s %q: must not start with '-'", kind, arg)
+	case strings.ContainsAny(arg, "\x00\r\n"):
+		return fmt.Errorf("invalid %s %q: must not contain NUL or newline characters", kind, arg)
+	}
+	return nil
+}
+
+// dangerousTransports are the git transports, in the <transport>::<address> syntax
+// of remote helpers, that are rejected unless allowed with AllowTransport.
+// The ext transport runs an arbitrary command, and fd uses arbitrary file descriptors.
+var dangerousTransports = []string{"ext", "fd"}
+
+// checkRemoteURL returns an error if remoteURL could be interpreted as a command-line
+// option, either by the version control system binary or by ssh, or if it uses one of
+// dangerousTransports that isn't in allowed.
+func checkRemoteURL(remoteURL string, allowed []string) error {
+	if err := checkArg("remote URL", remoteURL); err != nil {
+		return err
+	}
+	if i := strings.Index(remoteURL, "::"); i != -1 {
+		transport := strings.ToLower(remoteURL[:i])
+		if containsString(dangerousTransports, transport) && !containsString(allowed, transport) {
+			return fmt.Errorf("invalid remote URL %q: transport %q is not allowed", remoteURL, transport)
+		}
+	}
+	if host := sshHost(remoteURL); strings.HasPrefix(host, "-") {
+		// It would be passed to ssh as an option.
+		return fmt.Errorf("invalid remote URL %q: host must not start with '-'", remoteURL)
+	}
+	return nil
+}
+
+// sshHost returns the host of remoteURL, along with the user if there's one,
+// if it's an ssh URL, such as "ssh://user@host/path", "svn+ssh://host/path"
+// or the scp-like "user@host:path". Otherwise, it returns "".
+func sshHost(remoteURL string) string {
+	if i := strings.Index(remoteURL, "://"); i != -1 {
+		if !strings.Contains(strings.ToLower(remoteURL[:i]), "ssh") {
+			return ""
+		}
+		host := remoteURL[i+len("://"):]
+		if j := strings.IndexByte(host, '/'); j != -1 {
+			host = host[:j]
+		}
+		return host
+	}
+	// The scp-like syntax is only used when there's a colon before the first slash.
+	colon := strings.IndexByte(remoteURL, ':')
+	if colon == -1 || strings.Contains(remoteURL[:colon], "/") {
+		return ""
+	}
+	return remoteURL[:colon]
+}
@@ -0,0 +1,154 @@
+package vcsstate
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestCheckRemoteURL(t *testing.T) {
+	for _, tc := range []struct {
+		remoteURL string
+		allowed   []string
+		ok        bool
+	}{
+		{remoteURL: "https://github.com/shurcooL/vcsstate", ok: true},
+		{remoteURL: "git@github.com:shurcooL/vcsstate.git", ok: true},
+		{remoteURL: "ssh://git@github.com/shurcooL/vcsstate", ok: true},
+		{remoteURL: "/path/to/repo", ok: true},
+		{remoteURL: "./-repo", ok: true},
+		{remoteURL: "hg::https://example.com/repo", ok: true},
+		{remoteURL: "ext::ssh -i key host %S repo", allowed: []string{"ext"}, ok: true},
+		{remoteURL: ""},
+		{remoteURL: "--upload-pack=touch pwned"},
+		{remoteURL: "-oProxyCommand=touch pwned:repo"},
+		{remoteURL: "ssh://-oProxyCommand=touch pwned/repo"},
+		{remoteURL: "svn+ssh://-oProxyCommand=touch pwned/repo"},
+		{remoteURL: "ssh://-oProxyCommand=touch@host/repo"},
+		{remoteURL: "ext::sh -c touch% pwned"},
+		{remoteURL: "EXT::sh -c touch% pwned"},
+		{remoteURL: "fd::17"},
+		{remoteURL: "ext::sh -c touch% pwned", allowed: []string{"fd"}},
+		{remoteURL: "https://example.com/repo\n--upload-pack=touch pwned"},
+	} {
+		err := checkRemoteURL(tc.remoteURL, tc.allowed)
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("checkRemoteURL(%q, %q): got %v, want ok = %v", tc.remoteURL, tc.allowed, err, tc.ok)
+		}
+	}
+}
+
+func FuzzCheckArg(f *testing.F) {
+	for _, seed := range []string{"master", "HEAD~1", "-h", "--output=pwned", "a\nb", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, arg string) {
+		for _, bad := range []string{"-" + arg, "--" + arg, arg + "\n--output=pwned", arg + "\x00"} {
+			if checkArg("revision", bad) == nil {
+				t.Errorf("checkArg accepted %q", bad)
+			}
+		}
+		if err := checkArg("revision", arg); err != nil {
+			if !strings.Contains(err.Error(), "revision") {
+				t.Errorf("got error %q, want one that names the kind of argument", err)
+			}
+			return
+		}
+		// An accepted argument is never parsed as an option.
+		fs := flag.NewFlagSet("", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		if err := fs.Parse([]string{arg}); err != nil || fs.NArg() != 1 || fs.Arg(0) != arg {
+			t.Errorf("checkArg accepted %q, which is parsed as an option", arg)
+		}
+	})
+}
+
+func FuzzCheckRemoteURL(f *testing.F) {
+	for _, seed := range []string{
+		"https://example.com/repo",
+		"git@example.com:repo",
+		"--upload-pack=touch pwned",
+		"ssh://-oProxyCommand=touch pwned/repo",
+		"ext::sh -c touch% pwned",
+		"fd::17",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, remoteURL string) {
+		// Dangerous transports are rejected unless allowed, whatever their address.
+		for _, transport := range []string{"ext", "EXT", "fd"} {
+			u := transport + "::" + remoteURL
+			if checkRemoteURL(u, nil) == nil {
+				t.Errorf("checkRemoteURL accepted %q", u)
+			}
+			if err := checkRemoteURL(u, []string{"ext", "fd"}); err != nil && strings.Contains(err.Error(), "not allowed") {
+				t.Errorf("checkRemoteURL rejected %q with %s allowed: %v", u, transport, err)
+			}
+		}
+		if checkRemoteURL(remoteURL, nil) != nil {
+			return
+		}
+		if checkArg("remote URL", remoteURL) != nil {
+			t.Errorf("checkRemoteURL accepted invalid argument %q", remoteURL)
+		}
+		if strings.HasPrefix(sshHost(remoteURL), "-") {
+			t.Errorf("checkRemoteURL accepted ssh host that's an option in %q", remoteURL)
+		}
+	})
+}
+
+// FuzzGitArgs checks that untrusted revisions, branch names and remote URLs
+// can't inject options into git commands.
+func FuzzGitArgs(f *testing.F) {
+	if _, err := exec.LookPath("git"); err != nil {
+		f.Skip("git binary not available")
+	}
+	dir, pwned := tempGitRepo(f), f.TempDir()
+	marker := filepath.Join(pwned, "pwned")
+	for _, seed := range []string{
+		"master",
+		dir,
+		"--output=" + marker,
+		"--upload-pack=touch " + marker,
+		"--exec=touch " + marker,
+		"-oProxyCommand=touch " + marker + ":repo",
+		"ssh://-oProxyCommand=touch " + marker + "/repo",
+		"ext::sh -c touch% " + marker,
+		"--contains",
+		"--end-of-options",
+	} {
+		f.Add(seed)
+	}
+	v, err := NewVCS(vcs.ByCmd("git"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	rv, err := NewRemoteVCS(vcs.ByCmd("git"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Fuzz(func(t *testing.T, arg string) {
+		_, err1 := v.LocalRevision(dir, arg)
+		_, err2 := v.Contains(dir, arg, "master")
+		_, err3 := v.Contains(dir, "HEAD", arg)
+		_, err4 := v.RemoteContains(dir, arg, "master")
+		_, err5 := v.Branches(dir, arg)
+		_, _, err6 := rv.RemoteBranchAndRevision(arg)
+		if strings.HasPrefix(arg, "-") {
+			for _, err := range []error{err1, err2, err3, err4, err5, err6} {
+				if err == nil {
+					t.Errorf("got no error for %q", arg)
+				}
+			}
+		}
+		if _, err := os.Stat(marker); err == nil {
+			t.Fatalf("option injected with %q", arg)
+		}
+	})
+}
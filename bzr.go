@@ -0,0 +1,391 @@
+package vcsstate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/go/osutil"
+)
+
+// bzrBinary is the Breezy binary if available, otherwise the Bazaar one.
+var bzrBinary, bzrBinaryError = func() (string, error) {
+	if path, err := exec.LookPath("brz"); err == nil {
+		return path, nil
+	}
+	return exec.LookPath("bzr")
+}()
+
+// bzrCommand returns a command that runs bzr, or brz, with args in dir.
+// User-defined aliases are not used, so they can't affect the output.
+func bzrCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command(bzrBinary, append([]string{"--no-aliases"}, args...)...)
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	env.Set("BZR_PROGRESS_BAR", "none")
+	env.Set("BRZ_PROGRESS_BAR", "none")
+	cmd.Env = env
+	return cmd
+}
+
+type bzr struct{}
+
+func (bzr) Info(dir string) (Info, error) {
+	return Info{}, nil
+}
+
+func (bzr) Status(dir string) (string, error) {
+	cmd := bzrCommand(dir, "status", "--short")
+
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err == nil:
+		return string(stdout), nil
+	case bytes.Contains(stderr, []byte("ERROR: No WorkingTree exists for")):
+		return "", ErrNoWorkingTree
+	default:
+		return "", err
+	}
+}
+
+// Branch returns the branch nick.
+func (bzr) Branch(dir string) (string, error) {
+	cmd := bzrCommand(dir, "nick")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (bzr) Operations(dir string) ([]Operation, error) {
+	return nil, errors.New("not implemented for bzr")
+}
+
+// LocalRevision returns the revision id of the branch tip.
+// Each bzr branch is a separate directory, so defaultBranch is not used.
+func (bzr) LocalRevision(dir string, defaultBranch string) (string, error) {
+	return bzrRevisionInfo(dir, ".")
+}
+
+func (bzr) Branches(dir string, defaultBranch string) ([]LocalBranch, error) {
+	return nil, errors.New("not implemented for bzr")
+}
+
+// UnpushedCommits returns revisions that are missing from the parent branch.
+// If there's no parent branch, then ErrNoRemote is returned.
+func (bzr) UnpushedCommits(dir string, limit int) ([]Commit, error) {
+	revs, err := bzrMissing(dir)
+	if err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	for _, r := range revs {
+		if limit > 0 && len(commits) == limit {
+			break
+		}
+		commits = append(commits, r.commit())
+	}
+	return commits, nil
+}
+
+func (bzr) Stash(dir string) (string, error) {
+	cmd := bzrCommand(dir, "shelve", "--list")
+
+	stdout, stderr, err := dividedOutput(cmd)
+	switch ee, _ := err.(*exec.ExitError); {
+	case err == nil:
+		return string(stdout), nil
+	case ee != nil && ee.ExitCode() == 1 && !bytes.Contains(stderr, []byte("ERROR:")):
+		// bzr shelve --list exits with status 1 when there are shelved changes.
+		return string(stdout), nil
+	case bytes.Contains(stderr, []byte("ERROR: No WorkingTree exists for")):
+		return "", ErrNoWorkingTree
+	default:
+		return "", err
+	}
+}
+
+func (b bzr) StashEntries(dir string) ([]StashEntry, error) {
+	out, err := b.Stash(dir)
+	if err != nil {
+		return nil, err
+	}
+	return parseBzrShelveList([]byte(out)), nil
+}
+
+func (bzr) Submodules(dir string) ([]Submodule, error) {
+	return nil, errors.New("not implemented for bzr")
+}
+
+// Contains reports whether the branch contains the revision id, either
+// in its mainline or as a merged revision. Each bzr branch is a separate directory,
+// so defaultBranch is not used.
+func (bzr) Contains(dir string, revision string, defaultBranch string) (bool, error) {
+	return bzrLogContains(dir, ".", revision)
+}
+
+// RemoteContains reports whether the parent branch contains the revision id.
+// Each bzr branch is a separate directory, so defaultBranch is not used.
+func (b bzr) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
+	local, err := b.Contains(dir, revision, defaultBranch)
+	if err != nil {
+		return false, err
+	}
+	if !local {
+		// Not in the local branch, so bzr missing can't tell; ask the parent branch directly.
+		remoteURL, err := b.RemoteURL(dir)
+		if err != nil {
+			return false, err
+		}
+		if err := checkRemoteURL(remoteURL, nil); err != nil {
+			return false, err
+		}
+		return bzrLogContains(dir, remoteURL, revision)
+	}
+	missing, err := bzrMissing(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range missing {
+		if r.ID == revision {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// RemoteURL returns the parent branch location, or the push location
+// if there's no parent branch.
+func (bzr) RemoteURL(dir string) (string, error) {
+	cmd := bzrCommand(dir, "info")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	locations := parseBzrInfo(out)
+	for _, name := range []string{"parent branch", "push branch"} {
+		if url, ok := locations[name]; ok {
+			return url, nil
+		}
+	}
+	return "", ErrNoRemote
+}
+
+func (b bzr) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
+	remoteURL, err := b.RemoteURL(dir)
+	if err != nil {
+		return "", "", err
+	}
+	return remoteBzr{}.RemoteBranchAndRevision(remoteURL)
+}
+
+func (bzr) CachedRemoteDefaultBranch() (string, error) {
+	return "", fmt.Errorf("not implemented for bzr, just use NoRemoteDefaultBranch")
+}
+
+func (bzr) NoRemoteDefaultBranch() string {
+	return "trunk"
+}
+
+type remoteBzr struct{}
+
+// RemoteBranchAndRevision returns the nick and tip revision id of the branch at remoteURL.
+func (remoteBzr) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
+	if err := checkRemoteURL(remoteURL, nil); err != nil {
+		return "", "", err
+	}
+	revision, err = bzrRevisionInfo("", remoteURL)
+	if err != nil {
+		return "", "", err
+	}
+	cmd := bzrCommand("", "nick", "--directory="+remoteURL)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), revision, nil
+}
+
+// bzrRevisionInfo returns the tip revision id of the branch at location.
+// It returns NotFoundError if there's no branch at location.
+func bzrRevisionInfo(dir, location string) (string, error) {
+	cmd := bzrCommand(dir, "revision-info", "--directory="+location)
+
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err != nil && bytes.Contains(stderr, []byte("ERROR: Not a branch")):
+		return "", NotFoundError{Err: fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))}
+	case err != nil:
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	// Output is "<revno> <revision-id>", or just "<revno>" for an empty branch.
+	fields := strings.Fields(string(stdout))
+	if len(fields) != 2 {
+		return "", fmt.Errorf("unexpected bzr revision-info output: %q", stdout)
+	}
+	return fields[1], nil
+}
+
+// bzrLogContains reports whether the branch at location contains the revision id.
+func bzrLogContains(dir, location, revision string) (bool, error) {
+	if err := checkArg("revision", revision); err != nil {
+		return false, err
+	}
+	cmd := bzrCommand(dir, "log", "--limit", "1", "--line", "--revision", "revid:"+revision, "--", location)
+
+	_, stderr, err := dividedOutput(cmd)
+	switch {
+	case err == nil:
+		return true, nil
+	case bytes.Contains(stderr, []byte("does not exist in branch")):
+		return false, nil // Unknown revision error means this revision is not contained.
+	default:
+		return false, fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+}
+
+// bzrMissing returns the revisions of the branch at dir that are missing
+// from its parent branch. If there's no parent branch, then ErrNoRemote is returned.
+func bzrMissing(dir string) ([]bzrRevision, error) {
+	cmd := bzrCommand(dir, "missing", "--mine-only", "--long", "--show-ids")
+
+	stdout, stderr, err := dividedOutput(cmd)
+	if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() == 1 {
+		// Exit code 1 means the branches differ, which is expected.
+		err = nil
+	}
+	switch {
+	case err == nil:
+		return parseBzrLog(stdout)
+	case bytes.Contains(stderr, []byte("ERROR: No peer location known or specified")):
+		return nil, ErrNoRemote
+	default:
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+}
+
+// bzrRevision is a revision, as reported by bzr log --long --show-ids.
+type bzrRevision struct {
+	ID        string
+	Committer string
+	Nick      string
+	Time      time.Time
+	Message   string
+}
+
+func (r bzrRevision) commit() Commit {
+	return Commit{
+		Revision: r.ID,
+		Author:   r.Committer,
+		Time:     r.Time,
+		Subject:  strings.SplitN(r.Message, "\n", 2)[0],
+		Branch:   r.Nick,
+	}
+}
+
+// bzrLogSeparator separates revisions in bzr log --long output.
+const bzrLogSeparator = "------------------------------------------------------------"
+
+// parseBzrLog parses bzr log --long --show-ids output. Lines preceding
+// the first revision, such as the summary printed by bzr missing, are skipped.
+func parseBzrLog(out []byte) ([]bzrRevision, error) {
+	var revs []bzrRevision
+	var r *bzrRevision
+	var message []string
+	flush := func() {
+		if r == nil {
+			return
+		}
+		r.Message = strings.TrimSpace(strings.Join(message, "\n"))
+		revs = append(revs, *r)
+		r, message = nil, nil
+	}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := strings.TrimLeft(s.Text(), " ") // Merged revisions are indented.
+		switch {
+		case line == bzrLogSeparator:
+			flush()
+			r = &bzrRevision{}
+			continue
+		case r == nil:
+			continue
+		case message != nil:
+			message = append(message, strings.TrimPrefix(line, "  "))
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon == -1 {
+			continue
+		}
+		key, value := line[:colon], strings.TrimSpace(line[colon+1:])
+		switch key {
+		case "revision-id":
+			r.ID = value
+		case "committer":
+			r.Committer = value
+		case "branch nick":
+			r.Nick = value
+		case "timestamp":
+			t, err := time.Parse("Mon 2006-01-02 15:04:05 -0700", value)
+			if err != nil {
+				return nil, err
+			}
+			r.Time = t
+		case "message":
+			message = []string{}
+		}
+	}
+	flush()
+	return revs, s.Err()
+}
+
+// parseBzrInfo parses bzr info output, and returns the related branch locations,
+// such as "parent branch" and "push branch".
+func parseBzrInfo(out []byte) map[string]string {
+	locations := make(map[string]string)
+	section := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" && !strings.HasPrefix(line, " ") {
+			section = strings.TrimSuffix(line, ":")
+			continue
+		}
+		if section != "Related branches" {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon == -1 {
+			continue
+		}
+		locations[strings.TrimSpace(line[:colon])] = strings.TrimSpace(line[colon+1:])
+	}
+	return locations
+}
+
+// parseBzrShelveList parses bzr shelve --list output.
+// Entries have no branch, time, base or files, since bzr doesn't list them.
+func parseBzrShelveList(out []byte) []StashEntry {
+	var entries []StashEntry
+	for _, line := range strings.Split(string(out), "\n") {
+		// Each line is "  <id>: <message>".
+		line = strings.TrimSpace(line)
+		colon := strings.Index(line, ":")
+		if colon == -1 {
+			continue
+		}
+		entries = append(entries, StashEntry{
+			Name:    line[:colon],
+			Message: strings.TrimSpace(line[colon+1:]),
+		})
+	}
+	return entries
+}
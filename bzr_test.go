@@ -0,0 +1,212 @@
+package vcsstate
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBzrLog(t *testing.T) {
+	// bzr missing --mine-only --long --show-ids
+	in := []byte(`You have 2 extra revisions:
+------------------------------------------------------------
+revno: 3 [merge]
+revision-id: gopher@example.com-20170714024000-8rbsf3ngu0p2e5a2
+parent: gopher@example.com-20170714023000-4c0yxl1ht6w1y2g0
+parent: gopher@example.com-20170714022000-a9m1v3bxq7e2jd0k
+committer: Gopher <gopher@example.com>
+branch nick: feature
+timestamp: Fri 2017-07-14 02:40:00 +0200
+message:
+  Merge work.
+
+  With more details.
+    ------------------------------------------------------------
+    revno: 2.1.1
+    revision-id: gopher@example.com-20170714022000-a9m1v3bxq7e2jd0k
+    parent: gopher@example.com-20170714021000-xq0a5m2c8r3v9t1b
+    committer: Other <other@example.com>
+    branch nick: work
+    timestamp: Fri 2017-07-14 00:20:00 +0000
+    message:
+      Work.
+`)
+	want := []bzrRevision{
+		{
+			ID:        "gopher@example.com-20170714024000-8rbsf3ngu0p2e5a2",
+			Committer: "Gopher <gopher@example.com>",
+			Nick:      "feature",
+			Time:      time.Date(2017, 7, 14, 0, 40, 0, 0, time.UTC),
+			Message:   "Merge work.\n\nWith more details.",
+		},
+		{
+			ID:        "gopher@example.com-20170714022000-a9m1v3bxq7e2jd0k",
+			Committer: "Other <other@example.com>",
+			Nick:      "work",
+			Time:      time.Date(2017, 7, 14, 0, 20, 0, 0, time.UTC),
+			Message:   "Work.",
+		},
+	}
+	got, err := parseBzrLog(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d revisions, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range got {
+		if !got[i].Time.Equal(want[i].Time) {
+			t.Errorf("revision %d: got time %v, want %v", i, got[i].Time, want[i].Time)
+		}
+		got[i].Time, want[i].Time = time.Time{}, time.Time{}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if c := want[0].commit(); c.Subject != "Merge work." || c.Branch != "feature" {
+		t.Errorf("got commit %+v", c)
+	}
+}
+
+func TestParseBzrInfo(t *testing.T) {
+	// bzr info
+	in := []byte(`Standalone tree (format: 2a)
+Location:
+  branch root: .
+
+Related branches:
+    push branch: bzr+ssh://example.com/feature
+  parent branch: /home/gopher/trunk
+`)
+	want := map[string]string{
+		"push branch":   "bzr+ssh://example.com/feature",
+		"parent branch": "/home/gopher/trunk",
+	}
+	if got := parseBzrInfo(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseBzrShelveList(t *testing.T) {
+	// bzr shelve --list
+	in := []byte("   2: Try a different approach\n   1: Changes shelved on 2017-07-14 02:40:00.000000\n")
+	want := []StashEntry{
+		{Name: "2", Message: "Try a different approach"},
+		{Name: "1", Message: "Changes shelved on 2017-07-14 02:40:00.000000"},
+	}
+	if got := parseBzrShelveList(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestBzr(t *testing.T) {
+	if bzrBinaryError != nil {
+		t.Skip("bzr or brz binary not available")
+	}
+	tmp := t.TempDir()
+	trunk := filepath.Join(tmp, "trunk")
+	feature := filepath.Join(tmp, "feature")
+	bzrRun(t, tmp, "init", "-q", trunk)
+	writeFile(t, filepath.Join(trunk, "file"), "initial\n")
+	bzrRun(t, trunk, "add", "-q", "file")
+	bzrRun(t, trunk, "commit", "-q", "-m", "initial")
+	bzrRun(t, tmp, "branch", "-q", trunk, feature)
+	writeFile(t, filepath.Join(feature, "file"), "feature\n")
+	bzrRun(t, feature, "commit", "-q", "-m", "feature")
+	base := strings.Fields(bzrRun(t, trunk, "revision-info"))[1]
+	tip := strings.Fields(bzrRun(t, feature, "revision-info"))[1]
+
+	var b VCS = bzr{}
+	if got, err := b.Branch(feature); err != nil || got != "feature" {
+		t.Errorf("Branch: got %q, %v, want feature", got, err)
+	}
+	if got, err := b.LocalRevision(feature, "trunk"); err != nil || got != tip {
+		t.Errorf("LocalRevision: got %q, %v, want %q", got, err, tip)
+	}
+	if got, err := b.Status(feature); err != nil || got != "" {
+		t.Errorf("Status: got %q, %v, want clean", got, err)
+	}
+	if got, err := b.Stash(feature); err != nil || got != "" {
+		t.Errorf("Stash: got %q, %v, want empty", got, err)
+	}
+	if got, err := b.RemoteURL(trunk); err != ErrNoRemote {
+		t.Errorf("RemoteURL of trunk: got %q, %v, want ErrNoRemote", got, err)
+	}
+	if got, err := b.RemoteURL(feature); err != nil || filepath.Clean(strings.TrimPrefix(got, "file://")) != trunk {
+		t.Errorf("RemoteURL: got %q, %v, want %q", got, err, trunk)
+	}
+	commits, err := b.UnpushedCommits(feature, 0)
+	if err != nil || len(commits) != 1 || commits[0].Revision != tip || commits[0].Subject != "feature" {
+		t.Errorf("UnpushedCommits: got %+v, %v, want the feature commit", commits, err)
+	}
+	for _, tc := range []struct {
+		revision string
+		contains bool
+		remote   bool
+	}{{base, true, true}, {tip, true, false}, {"gopher@example.com-20170714024000-missing", false, false}} {
+		if got, err := b.Contains(feature, tc.revision, "trunk"); err != nil || got != tc.contains {
+			t.Errorf("Contains(%q): got %v, %v, want %v", tc.revision, got, err, tc.contains)
+		}
+		if got, err := b.RemoteContains(feature, tc.revision, "trunk"); err != nil || got != tc.remote {
+			t.Errorf("RemoteContains(%q): got %v, %v, want %v", tc.revision, got, err, tc.remote)
+		}
+	}
+	branch, revision, err := b.RemoteBranchAndRevision(feature)
+	if err != nil || branch != "trunk" || revision != base {
+		t.Errorf("RemoteBranchAndRevision: got %q, %q, %v, want trunk, %q", branch, revision, err, base)
+	}
+	_, _, err = remoteBzr{}.RemoteBranchAndRevision(filepath.Join(tmp, "missing"))
+	if _, ok := err.(NotFoundError); !ok {
+		t.Errorf("RemoteBranchAndRevision of missing branch: got %v, want NotFoundError", err)
+	}
+}
+
+func TestBzrStash(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake bzr is a shell script")
+	}
+	defer func(binary string) { bzrBinary = binary }(bzrBinary)
+	bzrBinary = filepath.Join(t.TempDir(), "bzr")
+	for _, tc := range []struct {
+		script string
+		want   string
+		ok     bool
+	}{
+		{"echo 'No shelved changes.' >&2", "", true},
+		{"echo '  2: wip'; echo '  1: <no message>'; exit 1", "  2: wip\n  1: <no message>\n", true},
+		{"echo 'bzr: ERROR: No WorkingTree exists for \"file:///tmp/branch/\".' >&2; exit 3", "", false},
+		{"echo 'bzr: ERROR: Not a branch: \"/tmp/\".' >&2; exit 1", "", false},
+	} {
+		writeScript(t, bzrBinary, tc.script+"\n")
+		got, err := bzr{}.Stash(t.TempDir())
+		if got != tc.want || (err == nil) != tc.ok {
+			t.Errorf("%s: got %q, %v, want %q, ok = %v", tc.script, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestBzrRemoteURLArgs(t *testing.T) {
+	for _, remoteURL := range []string{"--help", "-Dhpss", ""} {
+		if _, _, err := (remoteBzr{}).RemoteBranchAndRevision(remoteURL); err == nil || !strings.Contains(err.Error(), "invalid remote URL") {
+			t.Errorf("RemoteBranchAndRevision(%q): got %v, want invalid remote URL error", remoteURL, err)
+		}
+	}
+}
+
+// bzrRun runs bzr, or brz, with args in dir, and returns its output.
+func bzrRun(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(bzrBinary, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "BZR_EMAIL=Gopher <gopher@example.com>", "BRZ_EMAIL=Gopher <gopher@example.com>", "HOME="+dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("bzr %v: %v: %s", args, err, out)
+	}
+	return string(out)
+}
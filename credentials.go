@@ -0,0 +1,201 @@
+package vcsstate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Credential is a username and password, or token, used to authenticate
+// with a remote repository over HTTP.
+type Credential struct {
+	Username string
+	Password string // Password or access token.
+}
+
+// CredentialProvider provides credentials for remote repositories.
+type CredentialProvider interface {
+	// Credential returns the credential to use for the remote repository
+	// at remoteURL, which has an http or https scheme. If it has none,
+	// it returns a zero Credential and a nil error.
+	//
+	// Errors shouldn't contain secrets, since they're returned to callers.
+	Credential(remoteURL *url.URL) (Credential, error)
+}
+
+// CredentialFunc is an adapter to allow the use of an ordinary function
+// as a CredentialProvider.
+type CredentialFunc func(remoteURL *url.URL) (Credential, error)
+
+// Credential calls f(remoteURL).
+func (f CredentialFunc) Credential(remoteURL *url.URL) (Credential, error) {
+	return f(remoteURL)
+}
+
+// StaticCredential returns a CredentialProvider that provides the same credential
+// for all remote repositories. For an access token, such as one for GitHub,
+// the username can be any non-empty value, like "x-access-token".
+func StaticCredential(username, password string) CredentialProvider {
+	return CredentialFunc(func(*url.URL) (Credential, error) {
+		return Credential{Username: username, Password: password}, nil
+	})
+}
+
+// EnvCredential returns a CredentialProvider that provides a credential for all
+// remote repositories from the environment variables named usernameVar and passwordVar.
+// If the password variable is unset or empty, there's no credential.
+func EnvCredential(usernameVar, passwordVar string) CredentialProvider {
+	return CredentialFunc(func(*url.URL) (Credential, error) {
+		password := os.Getenv(passwordVar)
+		if password == "" {
+			return Credential{}, nil
+		}
+		return Credential{Username: os.Getenv(usernameVar), Password: password}, nil
+	})
+}
+
+// NetrcCredential returns a CredentialProvider that provides credentials from the
+// netrc file at path, matching the remote repository host with its machine entries.
+// If path is empty, the file named by the NETRC environment variable is used,
+// or else .netrc (_netrc on Windows) in the user's home directory.
+// A missing file provides no credentials.
+func NetrcCredential(path string) CredentialProvider {
+	return CredentialFunc(func(remoteURL *url.URL) (Credential, error) {
+		path := path
+		if path == "" {
+			path = defaultNetrcPath()
+		}
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			return Credential{}, nil
+		} else if err != nil {
+			return Credential{}, err
+		}
+		return parseNetrc(data).lookup(remoteURL.Hostname()), nil
+	})
+}
+
+// defaultNetrcPath returns the path of the user's netrc file.
+func defaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// netrc is the parsed contents of a netrc file.
+type netrc struct {
+	machines map[string]Credential // Keyed by machine name.
+	fallback *Credential           // The default entry, if any.
+}
+
+// lookup returns the credential for host, or the default one if there's
+// no entry for host.
+func (n netrc) lookup(host string) Credential {
+	if c, ok := n.machines[host]; ok {
+		return c
+	}
+	if n.fallback != nil {
+		return *n.fallback
+	}
+	return Credential{}
+}
+
+// parseNetrc parses the contents of a netrc file. Only the first entry for
+// a machine is used. Macro definitions are skipped.
+func parseNetrc(data []byte) netrc {
+	n := netrc{machines: make(map[string]Credential)}
+	var (
+		c       *Credential // Entry being parsed, or nil if it's ignored.
+		machine string
+	)
+	done := func() {
+		if c == nil {
+			return
+		}
+		if machine == "" {
+			if n.fallback == nil {
+				n.fallback = c
+			}
+		} else if _, ok := n.machines[machine]; !ok {
+			n.machines[machine] = *c
+		}
+		c = nil
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Split(bufio.ScanWords)
+	for s.Scan() {
+		switch s.Text() {
+		case "machine":
+			done()
+			if !s.Scan() {
+				return n
+			}
+			c, machine = new(Credential), s.Text()
+		case "default":
+			done()
+			c, machine = new(Credential), ""
+		case "login":
+			if s.Scan() && c != nil {
+				c.Username = s.Text()
+			}
+		case "password":
+			if s.Scan() && c != nil {
+				c.Password = s.Text()
+			}
+		case "account":
+			s.Scan()
+		case "macdef":
+			// A macro definition lasts until an empty line, which ScanWords doesn't report,
+			// so stop parsing. Macros are conventionally at the end of the file.
+			done()
+			return n
+		}
+	}
+	done()
+	return n
+}
+
+// credentialFor returns the credential that provider provides for remoteURL.
+// Only remote URLs with an http or https scheme have credentials, since others,
+// such as ssh, authenticate differently.
+func credentialFor(provider CredentialProvider, remoteURL string) (Credential, error) {
+	if provider == nil {
+		return Credential{}, nil
+	}
+	u, err := url.Parse(remoteURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return Credential{}, nil
+	}
+	// Don't pass on any credentials included in the URL.
+	u.User = nil
+	c, err := provider.Credential(u)
+	if err != nil {
+		return Credential{}, fmt.Errorf("getting credential for %s: %v", u, err)
+	}
+	if strings.ContainsAny(c.Username+c.Password, "\x00\r\n") {
+		return Credential{}, errors.New("invalid credential: must not contain NUL or newline characters")
+	}
+	return c, nil
+}
+
+// credentialScope returns the scheme and host of remoteURL, which a credential
+// provided by credentialFor must only be used with.
+func credentialScope(remoteURL string) (scheme, host string) {
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return "", ""
+	}
+	return u.Scheme, u.Host
+}
@@ -0,0 +1,30 @@
+package vcsstate
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// FromDir inspects dir and its parents to determine the version control
+// system and repository root that dir belongs to, using the registered factories.
+// Unlike vcs.FromDir, it knows about Fossil, Jujutsu and version control systems
+// registered with Register, and prefers Jujutsu in a colocated jj and git repository.
+func FromDir(dir string) (vcsCmd *vcs.Cmd, root string, err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	for d := dir; ; {
+		if cmd := detect(d); cmd != nil {
+			return cmd, d, nil
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			break
+		}
+		d = parent
+	}
+	return nil, "", fmt.Errorf("directory %q is not using a known version control system", dir)
+}
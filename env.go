@@ -0,0 +1,98 @@
+package vcsstate
+
+import (
+	"os"
+	"strings"
+
+	"github.com/shurcooL/go/osutil"
+)
+
+// redirectingEnv is the environment variables that can make git or hg operate
+// on a repository other than the one in the directory they're run in, or read
+// unexpected configuration. They're commonly inherited from hooks and CI steps,
+// so they're removed from the environment of every command.
+var redirectingEnv = []string{
+	"GIT_DIR",
+	"GIT_WORK_TREE",
+	"GIT_INDEX_FILE",
+	"GIT_OBJECT_DIRECTORY",
+	"GIT_ALTERNATE_OBJECT_DIRECTORIES",
+	"GIT_COMMON_DIR",
+	"GIT_NAMESPACE",
+	"GIT_PREFIX",
+	"GIT_CONFIG",
+	"GIT_CONFIG_PARAMETERS",
+	"GIT_CONFIG_COUNT",
+	"GIT_CONFIG_GLOBAL",
+	"GIT_CONFIG_SYSTEM",
+	"HGRCPATH",
+}
+
+// redirectingEnvPrefixes are prefixes of environment variables that are removed
+// like redirectingEnv. GIT_CONFIG_KEY_<n> and GIT_CONFIG_VALUE_<n> set configuration,
+// along with GIT_CONFIG_COUNT.
+var redirectingEnvPrefixes = []string{
+	"GIT_CONFIG_KEY_",
+	"GIT_CONFIG_VALUE_",
+}
+
+// environment is the environment that a version control system binary is run with.
+type environment struct {
+	set   []string // Variables set on top of the sanitized process environment, in the form "key=value".
+	allow []string // Names of variables in redirectingEnv, or with redirectingEnvPrefixes, to keep from the process environment.
+}
+
+// environ returns the process environment with variables in redirectingEnv, or with
+// redirectingEnvPrefixes, removed unless allowed, and with output made stable and non-interactive. Then, the variables
+// in e.set are set on top of it.
+func (e environment) environ() osutil.Environ {
+	env := osutil.Environ(os.Environ())
+	for _, key := range redirectingEnv {
+		if !containsString(e.allow, key) {
+			env.Unset(key)
+		}
+	}
+	for _, kv := range os.Environ() {
+		i := strings.Index(kv, "=")
+		if i == -1 || containsString(e.allow, kv[:i]) {
+			continue
+		}
+		for _, prefix := range redirectingEnvPrefixes {
+			if strings.HasPrefix(kv[:i], prefix) {
+				env.Unset(kv[:i])
+			}
+		}
+	}
+	env.Set("LC_ALL", "C")              // Untranslated messages, since errors are matched by their text.
+	env.Set("GIT_OPTIONAL_LOCKS", "0")  // Don't take optional locks that could interfere with concurrent git commands.
+	env.Set("GIT_TERMINAL_PROMPT", "0") // Fail rather than prompt for credentials.
+	env.Set("HGPLAIN", "1")             // Ignore user configuration that affects output, such as ui.verbose or aliases.
+	env.Set("HGENCODING", "utf-8")      // Keep non-ASCII text intact, despite LC_ALL=C.
+	for _, kv := range e.set {
+		if i := strings.Index(kv, "="); i != -1 {
+			env.Set(kv[:i], kv[i+1:])
+		}
+	}
+	return env
+}
+
+// getenv returns the value of the environment variable key in env, which is
+// in the form "key=value", and whether it's set.
+func getenv(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], key+"=") {
+			return env[i][len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// containsString reports whether s is in ss.
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
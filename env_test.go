@@ -0,0 +1,41 @@
+package vcsstate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvironmentEnviron(t *testing.T) {
+	t.Setenv("GIT_DIR", "/elsewhere/.git")
+	t.Setenv("GIT_WORK_TREE", "/elsewhere")
+	t.Setenv("GIT_INDEX_FILE", "/elsewhere/.git/index")
+	t.Setenv("HGRCPATH", "/elsewhere/hgrc")
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+	t.Setenv("GIT_CONFIG_COUNT", "2")
+	t.Setenv("GIT_CONFIG_KEY_0", "core.fsmonitor")
+	t.Setenv("GIT_CONFIG_VALUE_0", "touch pwned")
+	t.Setenv("GIT_CONFIG_KEY_1", "core.pager")
+	t.Setenv("GIT_CONFIG_VALUE_1", "less")
+	t.Setenv("GIT_CONFIG_GLOBAL", "/elsewhere/gitconfig")
+	t.Setenv("GIT_CONFIG_SYSTEM", "/elsewhere/gitconfig")
+
+	env := environment{
+		set:   []string{"GIT_TERMINAL_PROMPT=1", "EXTRA=a=b"},
+		allow: []string{"HGRCPATH", "GIT_CONFIG_KEY_1"},
+	}.environ()
+	for _, want := range []string{"HGRCPATH=/elsewhere/hgrc", "GIT_CONFIG_KEY_1=core.pager", "LC_ALL=C", "GIT_OPTIONAL_LOCKS=0", "GIT_TERMINAL_PROMPT=1", "HGPLAIN=1", "EXTRA=a=b"} {
+		if !containsString(env, want) {
+			t.Errorf("got environment without %s", want)
+		}
+	}
+	for _, kv := range env {
+		for _, key := range []string{"GIT_DIR", "GIT_WORK_TREE", "GIT_INDEX_FILE", "GIT_CONFIG_COUNT", "GIT_CONFIG_KEY_0", "GIT_CONFIG_VALUE_0", "GIT_CONFIG_VALUE_1", "GIT_CONFIG_GLOBAL", "GIT_CONFIG_SYSTEM"} {
+			if strings.HasPrefix(kv, key+"=") {
+				t.Errorf("got environment with %s", kv)
+			}
+		}
+	}
+	if containsString(env, "LC_ALL=de_DE.UTF-8") {
+		t.Error("got environment with inherited LC_ALL")
+	}
+}
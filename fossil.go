@@ -0,0 +1,325 @@
+package vcsstate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/go/osutil"
+	"golang.org/x/tools/go/vcs"
+)
+
+// Fossil describes the Fossil version control system, which golang.org/x/tools/go/vcs
+// doesn't know about. It can be passed to NewVCS and NewRemoteVCS.
+var Fossil = &vcs.Cmd{
+	Name: "Fossil",
+	Cmd:  "fossil",
+
+	Scheme: []string{"https", "http", "ssh"},
+}
+
+var _, fossilBinaryError = exec.LookPath("fossil")
+
+// fossilCommand returns a command that runs fossil with args in dir.
+func fossilCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("fossil", args...)
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+	return cmd
+}
+
+type fossil struct{}
+
+func (fossil) Info(dir string) (Info, error) {
+	return Info{}, nil
+}
+
+func (fossil) Status(dir string) (string, error) {
+	cmd := fossilCommand(dir, "changes")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (fossil) Branch(dir string) (string, error) {
+	cmd := fossilCommand(dir, "branch", "current")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (fossil) Operations(dir string) ([]Operation, error) {
+	return nil, errors.New("not implemented for fossil")
+}
+
+func (fossil) LocalRevision(dir string, defaultBranch string) (string, error) {
+	if err := checkArg("branch", defaultBranch); err != nil {
+		return "", err
+	}
+	cmd := fossilCommand(dir, "info", defaultBranch)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return fossilInfoHash(parseFossilInfo(out))
+}
+
+func (fossil) Branches(dir string, defaultBranch string) ([]LocalBranch, error) {
+	return nil, errors.New("not implemented for fossil")
+}
+
+func (fossil) UnpushedCommits(dir string, limit int) ([]Commit, error) {
+	return nil, errors.New("not implemented for fossil")
+}
+
+func (fossil) Stash(dir string) (string, error) {
+	cmd := fossilCommand(dir, "stash", "list")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(string(out)) == "empty stash" {
+		return "", nil
+	}
+	return string(out), nil
+}
+
+func (f fossil) StashEntries(dir string) ([]StashEntry, error) {
+	out, err := f.Stash(dir)
+	if err != nil {
+		return nil, err
+	}
+	return parseFossilStashList([]byte(out))
+}
+
+func (fossil) Submodules(dir string) ([]Submodule, error) {
+	return nil, errors.New("not implemented for fossil")
+}
+
+// fossilMinHashPrefix is the minimum length of a hash prefix that fossil resolves.
+const fossilMinHashPrefix = 4
+
+// Contains reports whether the check-in specified by revision, a full hash
+// or a unique prefix of at least fossilMinHashPrefix characters, is an ancestor
+// of the tip of defaultBranch.
+func (fossil) Contains(dir string, revision string, defaultBranch string) (bool, error) {
+	if err := checkArg("revision", revision); err != nil {
+		return false, err
+	}
+	if err := checkArg("branch", defaultBranch); err != nil {
+		return false, err
+	}
+	revision = strings.ToLower(revision)
+	if len(revision) < fossilMinHashPrefix || strings.Trim(revision, "0123456789abcdef") != "" {
+		return false, fmt.Errorf("invalid fossil revision %q: must be a hash or a prefix of at least %d hex digits", revision, fossilMinHashPrefix)
+	}
+	hash := revision
+	if len(revision) != 40 && len(revision) != 64 {
+		// Resolve the prefix among all check-ins, so that it's not
+		// taken to mean an ancestor that happens to share it.
+		cmd := fossilCommand(dir, "timeline", "--limit", "0", "--type", "ci", "--format", "%H")
+		out, err := cmd.Output()
+		if err != nil {
+			return false, err
+		}
+		var matches []string
+		for _, h := range parseFossilTimelineHashes(out) {
+			if strings.HasPrefix(h, revision) {
+				matches = append(matches, h)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return false, nil
+		case 1:
+			hash = matches[0]
+		default:
+			return false, fmt.Errorf("ambiguous fossil revision %q: matches %d check-ins", revision, len(matches))
+		}
+	}
+
+	cmd := fossilCommand(dir, "timeline", "ancestors", defaultBranch, "--limit", "0", "--type", "ci", "--format", "%H")
+	out, err := cmd.Output()
+	if err != nil {
+		return false, err
+	}
+	for _, h := range parseFossilTimelineHashes(out) {
+		if h == hash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (fossil) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
+	return false, errors.New("not implemented for fossil")
+}
+
+// RemoteURL returns the remote URL. Fossil doesn't include the password, if any.
+func (fossil) RemoteURL(dir string) (string, error) {
+	cmd := fossilCommand(dir, "remote-url")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	url := strings.TrimSuffix(string(out), "\n")
+	if url == "" || url == "off" {
+		return "", ErrNoRemote
+	}
+	return url, nil
+}
+
+func (f fossil) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
+	remoteURL, err := f.RemoteURL(dir)
+	if err != nil {
+		return "", "", err
+	}
+	return remoteFossil{}.RemoteBranchAndRevision(remoteURL)
+}
+
+func (fossil) CachedRemoteDefaultBranch() (string, error) {
+	return "", fmt.Errorf("not implemented for fossil, just use NoRemoteDefaultBranch")
+}
+
+func (fossil) NoRemoteDefaultBranch() string {
+	return "trunk"
+}
+
+type remoteFossil struct{}
+
+// RemoteBranchAndRevision returns trunk and its latest check-in.
+//
+// Fossil has no way to query a remote without syncing with it, so the remote repository
+// is cloned to a temporary location. This downloads all of it, so it takes time and disk
+// space proportional to the size of the repository, unlike for other version control systems.
+func (remoteFossil) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
+	const defaultBranch = "trunk"
+
+	if err := checkRemoteURL(remoteURL, nil); err != nil {
+		return "", "", err
+	}
+	tempDir, err := ioutil.TempDir("", "vcsstate-fossil-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(tempDir)
+	repo := filepath.Join(tempDir, "remote.fossil")
+
+	cmd := fossilCommand(tempDir, "clone", "--", remoteURL, repo)
+	env := osutil.Environ(cmd.Env)
+	env.Set("FOSSIL_HOME", tempDir) // Don't record the clone in the user's global configuration.
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	switch {
+	case err != nil && (bytes.Contains(out, []byte("404 Not Found")) ||
+		bytes.Contains(out, []byte("repository does not exist"))):
+		return "", "", NotFoundError{Err: fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(out), "\n"))}
+	case err != nil:
+		return "", "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(out), "\n"))
+	}
+
+	cmd = fossilCommand(tempDir, "info", defaultBranch, "-R", repo)
+	out, err = cmd.Output()
+	if err != nil {
+		return "", "", err
+	}
+	revision, err = fossilInfoHash(parseFossilInfo(out))
+	if err != nil {
+		return "", "", err
+	}
+	return defaultBranch, revision, nil
+}
+
+// parseFossilInfo parses fossil info output into its fields.
+func parseFossilInfo(out []byte) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		colon := strings.Index(line, ":")
+		if colon == -1 || strings.HasPrefix(line, " ") {
+			continue
+		}
+		fields[line[:colon]] = strings.TrimSpace(line[colon+1:])
+	}
+	return fields
+}
+
+// fossilInfoHash returns the check-in hash from fossil info fields.
+// Older versions of fossil call it "uuid" rather than "hash".
+func fossilInfoHash(fields map[string]string) (string, error) {
+	for _, name := range []string{"hash", "uuid"} {
+		if f := strings.Fields(fields[name]); len(f) != 0 {
+			return f[0], nil
+		}
+	}
+	return "", fmt.Errorf("no check-in hash in fossil info output: %v", fields)
+}
+
+// parseFossilTimelineHashes parses fossil timeline --format %H output,
+// skipping lines, such as "+++ no more data (3) +++", that aren't hashes.
+func parseFossilTimelineHashes(out []byte) []string {
+	var hashes []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" || strings.Trim(line, "0123456789abcdef") != "" {
+			continue
+		}
+		hashes = append(hashes, line)
+	}
+	return hashes
+}
+
+// parseFossilStashList parses fossil stash list output.
+// Base is a hash prefix, since fossil abbreviates it.
+func parseFossilStashList(out []byte) ([]StashEntry, error) {
+	var entries []StashEntry
+	for _, line := range strings.Split(string(out), "\n") {
+		// Each entry is "<id>: [<hash>] on <date>", followed by indented comment lines.
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" {
+			continue
+		}
+		colon := strings.Index(trimmed, ": [")
+		if colon == -1 {
+			if len(entries) != 0 {
+				e := &entries[len(entries)-1]
+				e.Message = strings.TrimSpace(e.Message + " " + strings.TrimSpace(line))
+			}
+			continue
+		}
+		if _, err := strconv.Atoi(trimmed[:colon]); err != nil {
+			return nil, fmt.Errorf("unexpected fossil stash list line: %q", line)
+		}
+		rest := trimmed[colon+len(": ["):]
+		end := strings.Index(rest, "] on ")
+		if end == -1 {
+			return nil, fmt.Errorf("unexpected fossil stash list line: %q", line)
+		}
+		t, err := time.Parse("2006-01-02 15:04:05", rest[end+len("] on "):])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, StashEntry{
+			Name: trimmed[:colon],
+			Base: rest[:end],
+			Time: t,
+		})
+	}
+	return entries, nil
+}
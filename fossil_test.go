@@ -0,0 +1,145 @@
+package vcsstate
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFossilInfo(t *testing.T) {
+	// fossil info trunk
+	in := []byte(`hash:         7cafcd837844e784b526369c9bce262804aebc60a2e1f1c4c5b9b1e6d3f0a1b2 2017-07-14 02:40:00 UTC
+parent:       0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a67253a98dcf0dd3273ea5892 2017-07-14 02:30:00 UTC
+tags:         trunk
+comment:      Add feature. (user: gopher)
+`)
+	got, err := fossilInfoHash(parseFossilInfo(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "7cafcd837844e784b526369c9bce262804aebc60a2e1f1c4c5b9b1e6d3f0a1b2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Older versions of fossil.
+	in = []byte("uuid:         7cafcd837844e784b526369c9bce262804aebc60 2017-07-14 02:40:00 UTC\n")
+	got, err = fossilInfoHash(parseFossilInfo(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "7cafcd837844e784b526369c9bce262804aebc60"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseFossilTimelineHashes(t *testing.T) {
+	// fossil timeline ancestors trunk --limit 0 --type ci --format %H
+	in := []byte("7cafcd837844e784b526369c9bce262804aebc60\n0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a\n+++ no more data (2) +++\n")
+	want := []string{"7cafcd837844e784b526369c9bce262804aebc60", "0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a"}
+	if got := parseFossilTimelineHashes(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseFossilStashList(t *testing.T) {
+	// fossil stash list
+	in := []byte(`    2: [7cafcd837844e7] on 2017-07-14 02:40:00
+          Try a different
+          approach.
+    1: [0a50dc0e5a012d] on 2017-07-14 02:30:00
+`)
+	want := []StashEntry{
+		{Name: "2", Base: "7cafcd837844e7", Time: time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC), Message: "Try a different approach."},
+		{Name: "1", Base: "0a50dc0e5a012d", Time: time.Date(2017, 7, 14, 2, 30, 0, 0, time.UTC)},
+	}
+	got, err := parseFossilStashList(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestFossil(t *testing.T) {
+	if fossilBinaryError != nil {
+		t.Skip("fossil binary not available")
+	}
+	tmp := t.TempDir()
+	repo := filepath.Join(tmp, "repo.fossil")
+	wc := filepath.Join(tmp, "wc")
+	if err := os.Mkdir(wc, 0755); err != nil {
+		t.Fatal(err)
+	}
+	fossilRun(t, tmp, "init", repo)
+	fossilRun(t, wc, "open", repo)
+	writeFile(t, filepath.Join(wc, "file"), "initial\n")
+	fossilRun(t, wc, "add", "file")
+	fossilRun(t, wc, "commit", "-m", "initial", "--no-warnings")
+	tip := strings.TrimSpace(fossilRun(t, wc, "timeline", "--limit", "1", "--type", "ci", "--format", "%H"))
+	tip = parseFossilTimelineHashes([]byte(tip))[0]
+
+	var f VCS = fossil{}
+	if got, err := f.Branch(wc); err != nil || got != "trunk" {
+		t.Errorf("Branch: got %q, %v, want trunk", got, err)
+	}
+	if got, err := f.LocalRevision(wc, "trunk"); err != nil || got != tip {
+		t.Errorf("LocalRevision: got %q, %v, want %q", got, err, tip)
+	}
+	if got, err := f.Status(wc); err != nil || got != "" {
+		t.Errorf("Status: got %q, %v, want clean", got, err)
+	}
+	if got, err := f.Stash(wc); err != nil || got != "" {
+		t.Errorf("Stash: got %q, %v, want empty", got, err)
+	}
+	if got, err := f.RemoteURL(wc); err != ErrNoRemote {
+		t.Errorf("RemoteURL: got %q, %v, want ErrNoRemote", got, err)
+	}
+	for _, tc := range []struct {
+		revision string
+		want     bool
+	}{{tip, true}, {tip[:10], true}, {strings.ToUpper(tip[:4]), true}, {"0000000000", false}} {
+		if got, err := f.Contains(wc, tc.revision, "trunk"); err != nil || got != tc.want {
+			t.Errorf("Contains(%q): got %v, %v, want %v", tc.revision, got, err, tc.want)
+		}
+	}
+	for _, revision := range []string{tip[:3], "", "--help", "trunk"} {
+		if _, err := f.Contains(wc, revision, "trunk"); err == nil {
+			t.Errorf("Contains(%q): got no error", revision)
+		}
+	}
+	branch, revision, err := remoteFossil{}.RemoteBranchAndRevision(repo)
+	if err != nil || branch != "trunk" || revision != tip {
+		t.Errorf("RemoteBranchAndRevision: got %q, %q, %v, want trunk, %q", branch, revision, err, tip)
+	}
+}
+
+func TestFossilArgs(t *testing.T) {
+	for _, remoteURL := range []string{"--help", "-R/tmp/repo.fossil", ""} {
+		if _, _, err := (remoteFossil{}).RemoteBranchAndRevision(remoteURL); err == nil || !strings.Contains(err.Error(), "invalid remote URL") {
+			t.Errorf("RemoteBranchAndRevision(%q): got %v, want invalid remote URL error", remoteURL, err)
+		}
+	}
+	for _, branch := range []string{"--help", "-R/tmp/repo.fossil", ""} {
+		if _, err := (fossil{}).LocalRevision(t.TempDir(), branch); err == nil || !strings.Contains(err.Error(), "invalid branch") {
+			t.Errorf("LocalRevision(%q): got %v, want invalid branch error", branch, err)
+		}
+	}
+}
+
+// fossilRun runs fossil with args in dir, and returns its output.
+func fossilRun(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("fossil", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "USER=gopher", "FOSSIL_USER=gopher", "FOSSIL_HOME="+dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("fossil %v: %v: %s", args, err, out)
+	}
+	return string(out)
+}
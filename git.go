@@ -0,0 +1,1399 @@
+package vcsstate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/go/osutil"
+	"github.com/shurcooL/vcsstate/remoteurl"
+)
+
+// git implements git support using a git 1.7+ binary. Optional features,
+// such as ls-remote --symref, are used when the binary supports them.
+type git struct {
+	binary          string             // Name or path of the git binary.
+	env             environment        // Environment to run the git binary with.
+	caps            gitCapabilities    // Capabilities of the git binary.
+	safe            bool               // Safe mode, see Options.SafeMode.
+	allowTransports []string           // Transports allowed despite being dangerous, see Options.AllowTransports.
+	credentials     CredentialProvider // Provider of credentials for remotes, if any.
+	ssh             SSHOptions         // Options for the ssh command.
+
+	recurseSubmodules bool // Report nested submodules, see Options.RecurseSubmodules.
+}
+
+// command returns a command that runs git with args in dir.
+func (g git) command(dir string, args ...string) *exec.Cmd {
+	if g.safe {
+		args = append(g.safeConfig(dir), args...)
+	}
+	cmd := exec.Command(g.binary, args...)
+	cmd.Dir = dir
+	cmd.Env = g.env.environ()
+	return cmd
+}
+
+// gitCredentialHelper is a git credential helper that provides the credential
+// in the environment variables set by remoteCommand, so that it doesn't appear
+// in command-line arguments. It only answers for the protocol and host that the
+// credential was provided for, so that it's not sent to another host that the
+// remote redirects to.
+// It uses printf rather than echo, which interprets backslashes in some shells.
+const gitCredentialHelper = `!f() { test "$1" = get || exit 0; ` +
+	`while IFS= read -r l && test -n "$l"; do case "$l" in protocol=*) p=${l#protocol=};; host=*) h=${l#host=};; esac; done; ` +
+	`test "$p" = "$VCSSTATE_PROTOCOL" && test "$h" = "$VCSSTATE_HOST" || exit 0; ` +
+	`printf '%s\n' "username=$VCSSTATE_USERNAME" "password=$VCSSTATE_PASSWORD"; }; f`
+
+// remoteCommand is like command, but for commands that access the remote at remoteURL,
+// or the origin remote of the repository at dir if remoteURL is empty.
+// Dangerous transports can't be used, unless allowed. A credential provided for
+// the remote is used instead of configured credential helpers.
+func (g git) remoteCommand(dir, remoteURL string, args ...string) (*exec.Cmd, error) {
+	var config []string
+	for _, transport := range dangerousTransports {
+		allow := "never"
+		if containsString(g.allowTransports, transport) {
+			allow = "always"
+		}
+		config = append(config, "-c", "protocol."+transport+".allow="+allow)
+	}
+	var cred Credential
+	if g.credentials != nil {
+		if remoteURL == "" {
+			// If there's no origin, the command reports it.
+			remoteURL, _ = g.RemoteURL(dir)
+		}
+		var err error
+		cred, err = credentialFor(g.credentials, remoteURL)
+		if err != nil {
+			return nil, err
+		}
+		if cred != (Credential{}) {
+			config = append(config, "-c", "credential.helper=", "-c", "credential.helper="+gitCredentialHelper)
+		}
+	}
+	cmd := g.command(dir, append(config, args...)...)
+	env := osutil.Environ(cmd.Env)
+	env.Set("GIT_ASKPASS", "true") // `true` here is not a boolean value, but a command /bin/true that will make git think it asked for a password, and prevent potential interactive password prompts (opting to return failure exit code instead).
+	if c := g.sshCommand(dir, env); c != "" {
+		env.Set("GIT_SSH_COMMAND", c)
+	}
+	if cred != (Credential{}) {
+		protocol, host := credentialScope(remoteURL)
+		env.Set("VCSSTATE_PROTOCOL", protocol)
+		env.Set("VCSSTATE_HOST", host)
+		env.Set("VCSSTATE_USERNAME", cred.Username)
+		env.Set("VCSSTATE_PASSWORD", cred.Password)
+	}
+	cmd.Env = env
+	return cmd, nil
+}
+
+// sshCommand returns the ssh command line for remote commands run in dir with env.
+// Unless the ssh binary is set in options, the ssh command configured for git is used,
+// in the order of git's precedence: GIT_SSH_COMMAND, core.sshCommand, then GIT_SSH.
+// In safe mode, core.sshCommand isn't used, since the repository configuration isn't trusted.
+//
+// Options are only added for OpenSSH, since other variants, such as PuTTY's plink,
+// don't accept them. A configured command line is taken to be OpenSSH unless
+// gitSSHVariant says otherwise, but GIT_SSH, which is the path of a program that's
+// often a wrapper script, only if it's known to be. If options can't be added to
+// GIT_SSH, sshCommand returns "", so that git runs it as is.
+func (g git) sshCommand(dir string, env []string) string {
+	if g.ssh.Binary != "" {
+		return g.ssh.command(shellQuote(g.ssh.Binary))
+	}
+	if c, _ := getenv(env, "GIT_SSH_COMMAND"); c != "" {
+		return g.sshCommandLine(c, env)
+	}
+	if !g.safe {
+		out, err := g.command(dir, "config", "core.sshCommand").Output()
+		if c := strings.TrimSpace(string(out)); err == nil && c != "" {
+			return g.sshCommandLine(c, env)
+		}
+	}
+	if path, _ := getenv(env, "GIT_SSH"); path != "" {
+		if gitSSHVariant(path, env) != "ssh" {
+			return ""
+		}
+		return g.ssh.command(shellQuote(path))
+	}
+	return g.ssh.command("ssh")
+}
+
+// sshCommandLine returns c, a configured ssh command line, with options added
+// unless it's known not to be OpenSSH.
+func (g git) sshCommandLine(c string, env []string) string {
+	if v := gitSSHVariant(sshProgram(c), env); v != "" && v != "ssh" {
+		return c
+	}
+	return g.ssh.command(c)
+}
+
+// gitSSHVariant returns the variant of the ssh program at path, like git determines it
+// without running the program: the value of GIT_SSH_VARIANT in env, if it's set, or else
+// "ssh" for OpenSSH, or "plink", "putty" or "tortoiseplink" for PuTTY's programs, based
+// on the program's name. It returns "" if the variant isn't known.
+func gitSSHVariant(path string, env []string) string {
+	if v, _ := getenv(env, "GIT_SSH_VARIANT"); v != "" && v != "auto" {
+		return v
+	}
+	switch name := sshProgramName(path); name {
+	case "ssh", "plink", "putty", "tortoiseplink":
+		return name
+	}
+	return ""
+}
+
+// endOfOptions returns args followed by --end-of-options, if it's supported,
+// so that arguments after it can't be interpreted as options. Arguments must
+// still be validated with checkArg, since older git doesn't support it.
+func (g git) endOfOptions(args ...string) []string {
+	if g.caps.endOfOptions {
+		return append(args, "--end-of-options")
+	}
+	return args
+}
+
+// statusCommand returns a command that runs git status --porcelain in dir.
+// Where supported, optional locks are not taken, so that refreshing the index
+// as a side effect can't interfere with git commands run concurrently by the user.
+// In safe mode, submodule working trees aren't inspected, since git would use
+// their configuration, which safeConfig doesn't cover.
+func (g git) statusCommand(dir string) *exec.Cmd {
+	args := []string{"status", "--porcelain"}
+	if g.safe {
+		args = append(args, "--ignore-submodules=dirty")
+	}
+	if g.caps.noOptionalLocks {
+		args = append([]string{"--no-optional-locks"}, args...)
+	}
+	return g.command(dir, args...)
+}
+
+func (g git) Status(dir string) (string, error) {
+	cmd := g.statusCommand(dir)
+
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err != nil && gitNoWorkingTree(stderr):
+		return "", ErrNoWorkingTree
+	case err != nil:
+		return "", gitError(err, stderr)
+	}
+	return string(stdout), nil
+}
+
+func (g git) Branch(dir string) (string, error) {
+	cmd := g.command(dir, "rev-parse", "--abbrev-ref", "HEAD")
+
+	out, stderr, err := dividedOutput(cmd)
+	if err != nil {
+		return "", gitError(err, stderr)
+	}
+	// Since rev-parse is considered porcelain and may change, need to error-check its output.
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// gitRevisionLength is the length of a git revision hash.
+const gitRevisionLength = 40
+
+func (g git) LocalRevision(dir string, defaultBranch string) (string, error) {
+	if err := checkArg("branch", defaultBranch); err != nil {
+		return "", err
+	}
+	cmd := g.command(dir, append(g.endOfOptions("rev-parse", "--verify"), defaultBranch)...)
+
+	out, stderr, err := dividedOutput(cmd)
+	if err != nil {
+		return "", gitError(err, stderr)
+	}
+	if len(out) < gitRevisionLength {
+		return "", fmt.Errorf("output length %v is shorter than %v", len(out), gitRevisionLength)
+	}
+	return string(out[:gitRevisionLength]), nil
+}
+
+func (g git) Stash(dir string) (string, error) {
+	cmd := g.command(dir, "stash", "list")
+
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err != nil && gitNoWorkingTree(stderr):
+		return "", ErrNoWorkingTree
+	case err != nil:
+		return "", gitError(err, stderr)
+	}
+	return string(stdout), nil
+}
+
+func (g git) Contains(dir string, revision string, defaultBranch string) (bool, error) {
+	if err := checkArg("revision", revision); err != nil {
+		return false, err
+	}
+	if err := checkArg("branch", defaultBranch); err != nil {
+		return false, err
+	}
+	if !g.caps.forEachRefFilters {
+		return g.branchContains(dir, revision, defaultBranch, false)
+	}
+	return g.forEachRefContains(dir, revision, "refs/heads/"+defaultBranch)
+}
+
+func (g git) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
+	if err := checkArg("revision", revision); err != nil {
+		return false, err
+	}
+	if err := checkArg("branch", defaultBranch); err != nil {
+		return false, err
+	}
+	if !g.caps.forEachRefFilters {
+		return g.branchContains(dir, revision, "origin/"+defaultBranch, true)
+	}
+	return g.forEachRefContains(dir, revision, "refs/remotes/origin/"+defaultBranch)
+}
+
+// forEachRefContains reports whether ref contains revision, using for-each-ref --contains.
+func (g git) forEachRefContains(dir, revision, ref string) (bool, error) {
+	// --format=contains is just an arbitrary constant string that we look for in the output.
+	cmd := g.command(dir, append(g.endOfOptions("for-each-ref", "--format=contains", "--count=1", "--contains="+revision), ref)...)
+
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err == nil:
+		// If this commit is contained, the expected output is exactly "contains\n".
+		if bytes.Equal(stdout, []byte("contains\n")) {
+			return true, nil
+		}
+		return g.notContained(dir)
+	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("error: no such commit %s\n", revision))):
+		return g.notContained(dir) // No such commit error means this commit is not contained.
+	default:
+		return false, err
+	}
+}
+
+// branchContains reports whether branch contains revision, using git branch --contains,
+// for git older than 2.7. If remote is true, branch is a remote-tracking branch.
+func (g git) branchContains(dir, revision, branch string, remote bool) (bool, error) {
+	args := []string{"branch", "--contains=" + revision, branch}
+	if remote {
+		args = []string{"branch", "-r", "--contains=" + revision, branch}
+	}
+	cmd := g.command(dir, args...)
+
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err == nil:
+		// If this commit is contained, the expected output is exactly "* {branch}\n"
+		// or "  {branch}\n" if we're on another branch,
+		// where {branch} is the value of branch.
+		if bytes.Equal(stdout, []byte(fmt.Sprintf("* %s\n", branch))) ||
+			bytes.Equal(stdout, []byte(fmt.Sprintf("  %s\n", branch))) {
+			return true, nil
+		}
+		return g.notContained(dir)
+	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("error: no such commit %s\n", revision))):
+		return g.notContained(dir) // No such commit error means this commit is not contained.
+	default:
+		return false, err
+	}
+}
+
+// URLRewrites implements URLRewriter.
+func (g git) URLRewrites(dir string) (remoteurl.Rewrites, error) {
+	cmd := g.command(dir, "config", "-z", "--get-regexp", `^url\..*\.insteadof$`)
+
+	stdout, stderr, err := dividedOutput(cmd)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 {
+		// No matching configuration.
+		return nil, nil
+	} else if err != nil {
+		return nil, gitError(err, stderr)
+	}
+	return remoteurl.ParseGitRewrites(stdout), nil
+}
+
+func (g git) RemoteURL(dir string) (string, error) {
+	// We may be on a non-default branch with a different remote set. In order to get consistent results,
+	// we must assume default remote is "origin" and explicitly specify it here. If it doesn't exist,
+	// then we treat that as no remote (even if some other remote exists), because this is a simple
+	// and consistent thing to do.
+	if !g.caps.remoteGetURL {
+		cmd := g.command(dir, "remote", "-v")
+
+		out, stderr, err := dividedOutput(cmd)
+		if err != nil {
+			return "", gitError(err, stderr)
+		}
+		url, err := parseGit17Remote(out)
+		if err != nil {
+			return "", ErrNoRemote
+		}
+		return url, nil
+	}
+
+	cmd := g.command(dir, "remote", "get-url", "origin")
+
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err != nil && bytes.Equal(stderr, []byte("fatal: No such remote 'origin'\n")):
+		return "", ErrNoRemote
+	case err != nil:
+		return "", gitError(err, stderr)
+	}
+	return strings.TrimSuffix(string(stdout), "\n"), nil
+}
+
+func (g git) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
+	args := []string{"ls-remote", "origin", "HEAD", "refs/heads/*"}
+	if g.caps.lsRemoteSymref {
+		args = []string{"ls-remote", "--symref", "origin", "HEAD", "refs/heads/*"}
+	}
+	cmd, err := g.remoteCommand(dir, "", args...)
+	if err != nil {
+		return "", "", err
+	}
+
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err != nil && bytes.HasPrefix(stderr, []byte("fatal: 'origin' does not appear to be a git repository\n")):
+		return "", "", ErrNoRemote
+	case err != nil && bytes.HasPrefix(stderr, []byte("remote: Repository not found.\n")):
+		return "", "", NotFoundError{Err: fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))}
+	// TODO: Consider detecting connectivity errors specifically via "fatal: unable to access " prefix:
+	//
+	//       	(done with wi-fi turned off)
+	//       	gostatus $ git ls-remote --symref origin HEAD refs/heads/*
+	//       	fatal: unable to access 'https://github.com/shurcooL/gostatus/': Could not resolve host: github.com
+	case err != nil:
+		return "", "", gitError(err, stderr)
+	}
+	if !g.caps.lsRemoteSymref {
+		_, revision, err = parseGit17LsRemote(stdout)
+		if err != nil {
+			return "", "", err
+		}
+		branch, err = g.remoteBranch(dir)
+		if err != nil {
+			return "", "", err
+		}
+		return branch, revision, nil
+	}
+	branch, revision, err = parseGit28LsRemote(stdout)
+	switch {
+	case err == errBranchNotFound:
+		// Some git servers doesn't support --symref option of ls-remote, so we need to fall back.
+		branch, err = g.remoteBranch(dir)
+		if err != nil {
+			return "", "", err
+		}
+	case err != nil:
+		return "", "", err
+	}
+	return branch, revision, nil
+}
+
+// remoteBranch is still needed to reliably get remote default branch
+// when git or the git server doesn't support --symref option of ls-remote.
+func (g git) remoteBranch(dir string) (string, error) {
+	cmd, err := g.remoteCommand(dir, "", "remote", "show", "origin")
+	if err != nil {
+		return "", err
+	}
+
+	stdout, stderr, err := dividedOutput(cmd)
+	if err != nil {
+		return "", gitError(err, stderr)
+	}
+	const s = "\n  HEAD branch: "
+	i := bytes.Index(stdout, []byte(s))
+	if i == -1 {
+		return "", errors.New("no HEAD branch")
+	}
+	i += len(s)
+	nl := bytes.IndexByte(stdout[i:], '\n')
+	if nl == -1 {
+		nl = len(stdout)
+	} else {
+		nl += i
+	}
+	return string(stdout[i:nl]), nil
+}
+
+func (git) CachedRemoteDefaultBranch() (string, error) {
+	// TODO: Apply more effort to actually get a cached remote default branch.
+	//       For now, just fall back to "master", but we can do better than that.
+	return "", fmt.Errorf("not yet implemented for git, fall back to NoRemoteDefaultBranch")
+}
+
+func (git) NoRemoteDefaultBranch() string {
+	return "master"
+}
+
+type remoteGit struct {
+	git git
+}
+
+func (r remoteGit) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
+	if err := checkRemoteURL(remoteURL, r.git.allowTransports); err != nil {
+		return "", "", err
+	}
+	if !r.git.caps.lsRemoteSymref {
+		cmd, err := r.git.remoteCommand("", remoteURL, "ls-remote", remoteURL, "HEAD", "refs/heads/*")
+		if err != nil {
+			return "", "", err
+		}
+
+		stdout, stderr, err := dividedOutput(cmd)
+		if err != nil {
+			return "", "", gitError(err, stderr)
+		}
+		return parseGit17LsRemote(stdout)
+	}
+
+	cmd, err := r.git.remoteCommand("", remoteURL, append(r.git.endOfOptions("ls-remote", "--symref"), remoteURL, "HEAD", "refs/heads/*")...)
+	if err != nil {
+		return "", "", err
+	}
+
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err != nil && bytes.HasPrefix(stderr, []byte("remote: Repository not found.\n")):
+		return "", "", NotFoundError{Err: fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))}
+	// TODO: Consider detecting connectivity errors specifically via "fatal: unable to access " prefix.
+	case err != nil:
+		return "", "", gitError(err, stderr)
+	}
+	branch, revision, err = parseGit28LsRemote(stdout)
+	switch {
+	case err == errBranchNotFound:
+		// Some git servers doesn't support --symref option of ls-remote, so we need to fall back.
+		// Use guessBranch for now, because it's the best option I can think of at this time.
+		branch, err = guessBranch(stdout, revision)
+		if err != nil {
+			return "", "", err
+		}
+	case err != nil:
+		return "", "", err
+	}
+	return branch, revision, nil
+}
+
+// gitError returns an error for a failed git command with stderr.
+// If git refused to use the repository because of its ownership,
+// the error is an UnsafeRepositoryError. If authenticating with the remote
+// failed, it's an AuthenticationError.
+func gitError(err error, stderr []byte) error {
+	err = fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	if dir, ok := gitUnsafeRepository(stderr); ok {
+		return UnsafeRepositoryError{Dir: dir, Err: err}
+	}
+	if gitAuthenticationFailed(stderr) {
+		return AuthenticationError{Err: err}
+	}
+	return err
+}
+
+// gitAuthenticationFailed reports whether stderr of a failed git remote command
+// indicates that authenticating with the remote failed, or would have required
+// a prompt, such as for a password or to accept an unknown ssh host key.
+func gitAuthenticationFailed(stderr []byte) bool {
+	for _, s := range [...]string{
+		"fatal: Authentication failed for ",
+		"fatal: could not read Username for ",
+		"fatal: could not read Password for ",
+		"Permission denied (", // ssh, e.g., "Permission denied (publickey)."
+		"Host key verification failed.",
+	} {
+		if bytes.Contains(stderr, []byte(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitNoWorkingTree reports whether stderr of a failed git command
+// indicates that it needs a working tree, but the repository has none.
+func gitNoWorkingTree(stderr []byte) bool {
+	return bytes.HasPrefix(stderr, []byte("fatal: this operation must be run in a work tree\n"))
+}
+
+// gitDir returns the path of the git directory for the working tree at dir.
+// For linked worktrees, this is the worktree's private git directory.
+func (g git) gitDir(dir string) (string, error) {
+	cmd := g.command(dir, "rev-parse", "--git-dir")
+	stdout, stderr, err := dividedOutput(cmd)
+	if err != nil {
+		return "", gitError(err, stderr)
+	}
+	gitDir := strings.TrimSuffix(string(stdout), "\n")
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	return gitDir, nil
+}
+
+// gitDirs returns the git directory and common git directory for dir,
+// and whether the repository is bare. The two directories are different
+// only for linked worktrees.
+func (g git) gitDirs(dir string) (gitDir, commonDir string, bare bool, err error) {
+	// Git older than 2.5 doesn't know --git-common-dir, and prints it back verbatim.
+	// That's fine, since it also doesn't support linked worktrees.
+	cmd := g.command(dir, "rev-parse", "--is-bare-repository", "--git-dir", "--git-common-dir")
+	stdout, stderr, err := dividedOutput(cmd)
+	if err != nil {
+		return "", "", false, gitError(err, stderr)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(stdout), "\n"), "\n")
+	if len(lines) != 3 {
+		return "", "", false, fmt.Errorf("unexpected rev-parse output: %q", stdout)
+	}
+	bare, gitDir, commonDir = lines[0] == "true", lines[1], lines[2]
+	if commonDir == "--git-common-dir" {
+		commonDir = gitDir
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(dir, commonDir)
+	}
+	return filepath.Clean(gitDir), filepath.Clean(commonDir), bare, nil
+}
+
+func (g git) Info(dir string) (Info, error) {
+	gitDir, commonDir, bare, err := g.gitDirs(dir)
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{
+		Bare:           bare,
+		LinkedWorktree: gitDir != commonDir,
+	}
+	if _, err := os.Stat(filepath.Join(commonDir, "shallow")); err == nil {
+		info.Shallow = true
+	}
+	// Config commands below exit with code 1 if no value is set.
+	// Partial clones are marked with extensions.partialClone in older versions of git,
+	// and remote.<name>.promisor in newer ones.
+	cmd := g.command(dir, "config", "--get-regexp", `^(extensions\.partialclone|remote\..*\.promisor)$`)
+	stdout, _, _ := dividedOutput(cmd)
+	for _, line := range strings.Split(string(stdout), "\n") {
+		// E.g., "extensions.partialclone origin" or "remote.origin.promisor true".
+		if strings.HasPrefix(line, "extensions.partialclone ") || strings.HasSuffix(line, ".promisor true") {
+			info.Partial = true
+		}
+	}
+	if bare {
+		cmd := g.command(dir, "config", "--bool", "remote.origin.mirror")
+		stdout, _, _ := dividedOutput(cmd)
+		info.Mirror = string(stdout) == "true\n"
+	}
+	return info, nil
+}
+
+// notContained is used by Contains and RemoteContains when revision is not found
+// to be contained. If the repository is shallow, that's not conclusive,
+// so ErrHistoryTruncated is returned instead.
+func (g git) notContained(dir string) (bool, error) {
+	_, commonDir, _, err := g.gitDirs(dir)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(filepath.Join(commonDir, "shallow")); err == nil {
+		return false, ErrHistoryTruncated
+	}
+	return false, nil
+}
+
+func (g git) MirrorStatus(dir string) (MirrorStatus, error) {
+	info, err := g.Info(dir)
+	if err != nil {
+		return MirrorStatus{}, err
+	}
+	if !info.Mirror {
+		return MirrorStatus{}, errors.New("not a mirror repository")
+	}
+	gitDir, err := g.gitDir(dir)
+	if err != nil {
+		return MirrorStatus{}, err
+	}
+	var status MirrorStatus
+	if fi, err := os.Stat(filepath.Join(gitDir, "FETCH_HEAD")); err == nil {
+		status.LastFetch = fi.ModTime()
+	}
+
+	cmd := g.command(dir, "for-each-ref", "--format=%(objectname)\t%(refname)")
+	stdout, stderr, err := dividedOutput(cmd)
+	if err != nil {
+		return MirrorStatus{}, gitError(err, stderr)
+	}
+	local := parseGitRefs(stdout)
+	cmd, err = g.remoteCommand(dir, "", "ls-remote", "origin")
+	if err != nil {
+		return MirrorStatus{}, err
+	}
+	stdout, stderr, err = dividedOutput(cmd)
+	if err != nil {
+		return MirrorStatus{}, gitError(err, stderr)
+	}
+	remote := parseGitRefs(stdout)
+
+	for ref, rev := range remote {
+		if local[ref] != rev {
+			status.Stale = append(status.Stale, ref)
+		}
+	}
+	for ref := range local {
+		if _, ok := remote[ref]; !ok {
+			status.Stale = append(status.Stale, ref)
+		}
+	}
+	sort.Strings(status.Stale)
+	return status, nil
+}
+
+// parseGitRefs parses lines of "<revision>\t<ref>", as output by ls-remote,
+// into a map of ref to revision. HEAD and peeled tags are skipped.
+func parseGitRefs(out []byte) map[string]string {
+	refs := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		// E.g., "7cafcd837844e784b526369c9bce262804aebc60	refs/heads/main".
+		revRef := strings.SplitN(line, "\t", 2)
+		if len(revRef) != 2 {
+			continue
+		}
+		rev, ref := revRef[0], revRef[1]
+		if ref == "HEAD" || strings.HasSuffix(ref, "^{}") {
+			continue
+		}
+		refs[ref] = rev
+	}
+	return refs
+}
+
+func (g git) Worktrees(dir string) ([]Worktree, error) {
+	if !g.caps.worktreeList {
+		return nil, errors.New("not implemented for git older than 2.7")
+	}
+	cmd := g.command(dir, "worktree", "list", "--porcelain")
+	stdout, stderr, err := dividedOutput(cmd)
+	if err != nil {
+		return nil, gitError(err, stderr)
+	}
+	worktrees, err := parseGitWorktreeList(stdout)
+	if err != nil {
+		return nil, err
+	}
+	for i := range worktrees {
+		w := &worktrees[i]
+		if w.Bare || w.Prunable {
+			continue
+		}
+		if _, err := os.Stat(w.Path); os.IsNotExist(err) {
+			// Git older than 2.31 doesn't report prunable worktrees.
+			w.Prunable = !w.Locked
+			continue
+		}
+		cmd := g.statusCommand(w.Path)
+		stdout, stderr, err := dividedOutput(cmd)
+		if err != nil {
+			return nil, gitError(err, stderr)
+		}
+		w.Dirty = len(stdout) != 0
+	}
+	return worktrees, nil
+}
+
+// parseGitWorktreeList parses the output of worktree list --porcelain.
+func parseGitWorktreeList(out []byte) ([]Worktree, error) {
+	var worktrees []Worktree
+	var w *Worktree
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			w = nil // Blank line terminates a worktree record.
+			continue
+		}
+		label, value := line, ""
+		if sp := strings.IndexByte(line, ' '); sp != -1 {
+			label, value = line[:sp], line[sp+1:]
+		}
+		if label == "worktree" {
+			worktrees = append(worktrees, Worktree{Path: value})
+			w = &worktrees[len(worktrees)-1]
+			continue
+		}
+		if w == nil {
+			return nil, fmt.Errorf("unexpected worktree list line: %q", line)
+		}
+		switch label {
+		case "HEAD":
+			w.Revision = value
+		case "branch":
+			w.Branch = strings.TrimPrefix(value, "refs/heads/")
+		case "bare":
+			w.Bare = true
+		case "locked":
+			w.Locked, w.LockReason = true, value
+		case "prunable":
+			w.Prunable = true
+		}
+	}
+	return worktrees, nil
+}
+
+// Operations looks for the state files that git commands
+// leave in the git directory while they're in progress.
+func (g git) Operations(dir string) ([]Operation, error) {
+	gitDir, err := g.gitDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	// readFile returns the first line of the named file in gitDir, or ok false if it doesn't exist.
+	readFile := func(name string) (line string, ok bool) {
+		b, err := ioutil.ReadFile(filepath.Join(gitDir, name))
+		if err != nil {
+			return "", false
+		}
+		return strings.SplitN(string(b), "\n", 2)[0], true
+	}
+	// readInt returns the integer in the named file in gitDir, or 0 if it's not available.
+	readInt := func(name string) int {
+		line, _ := readFile(name)
+		n, _ := strconv.Atoi(strings.TrimSpace(line))
+		return n
+	}
+
+	var ops []Operation
+	if rev, ok := readFile("MERGE_HEAD"); ok {
+		ops = append(ops, Operation{Kind: OperationMerge, Target: rev})
+	}
+	if headName, ok := readFile("rebase-merge/head-name"); ok {
+		// Interactive rebase, and non-interactive rebase in git 2.26+.
+		onto, _ := readFile("rebase-merge/onto")
+		ops = append(ops, Operation{
+			Kind:   OperationRebase,
+			Branch: strings.TrimPrefix(headName, "refs/heads/"),
+			Target: onto,
+			Step:   readInt("rebase-merge/msgnum"),
+			Total:  readInt("rebase-merge/end"),
+		})
+	} else if _, ok := readFile("rebase-apply/next"); ok {
+		// Apply based rebase, or git am.
+		op := Operation{Kind: OperationRebase, Step: readInt("rebase-apply/next"), Total: readInt("rebase-apply/last")}
+		if _, am := readFile("rebase-apply/applying"); am {
+			op.Kind = OperationAm
+		} else {
+			headName, _ := readFile("rebase-apply/head-name")
+			op.Branch = strings.TrimPrefix(headName, "refs/heads/")
+			op.Target, _ = readFile("rebase-apply/onto")
+		}
+		ops = append(ops, op)
+	}
+	rebasing := len(ops) > 0 && ops[len(ops)-1].Kind == OperationRebase
+	cherryPick, isCherryPick := readFile("CHERRY_PICK_HEAD")
+	if rebasing {
+		isCherryPick = false // Older versions of git leave CHERRY_PICK_HEAD when a rebase stops on a conflict.
+	}
+	revert, isRevert := readFile("REVERT_HEAD")
+	if !rebasing && !isCherryPick && !isRevert {
+		// A multi-commit cherry-pick or revert may be stopped between commits,
+		// in which case only the sequencer state is left.
+		if todo, ok := readFile("sequencer/todo"); ok {
+			isRevert = strings.HasPrefix(todo, "revert ")
+			isCherryPick = !isRevert
+		}
+	}
+	if isCherryPick {
+		ops = append(ops, Operation{Kind: OperationCherryPick, Target: cherryPick})
+	}
+	if isRevert {
+		ops = append(ops, Operation{Kind: OperationRevert, Target: revert})
+	}
+	if _, ok := readFile("BISECT_LOG"); ok {
+		start, _ := readFile("BISECT_START") // Branch or revision bisect was started from.
+		ops = append(ops, Operation{Kind: OperationBisect, Branch: start})
+	}
+	return ops, nil
+}
+
+func (g git) Submodules(dir string) ([]Submodule, error) {
+	cmd := g.command(dir, "rev-parse", "--show-toplevel")
+	stdout, stderr, err := dividedOutput(cmd)
+	if err != nil && gitNoWorkingTree(stderr) {
+		return nil, ErrNoWorkingTree
+	} else if err != nil {
+		return nil, gitError(err, stderr)
+	}
+	root := strings.TrimSuffix(string(stdout), "\n")
+	if root == "" { // Git older than 2.25 prints nothing when there's no working tree.
+		return nil, ErrNoWorkingTree
+	}
+
+	// Submodules are recorded in the index as gitlinks.
+	cmd = g.command(root, "ls-files", "-z", "--stage")
+	stdout, stderr, err = dividedOutput(cmd)
+	if err != nil {
+		return nil, gitError(err, stderr)
+	}
+	submodules, err := parseGitGitlinks(stdout)
+	if err != nil || len(submodules) == 0 {
+		return nil, err
+	}
+
+	cmd = g.command(root, "config", "-z", "--file", ".gitmodules", "--get-regexp", `^submodule\..*\.(path|url)$`)
+	stdout, stderr, err = dividedOutput(cmd)
+	if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() == 1 {
+		// Exit code 1 means there were no matches, which is also
+		// the case when .gitmodules doesn't exist.
+		err = nil
+	}
+	if err != nil {
+		return nil, gitError(err, stderr)
+	}
+	urls := parseGitModules(stdout)
+
+	for i := range submodules {
+		s := &submodules[i]
+		s.URL = urls[s.Path]
+		subdir := filepath.Join(root, filepath.FromSlash(s.Path))
+		if _, err := os.Stat(filepath.Join(subdir, ".git")); err != nil {
+			continue // Not initialized.
+		}
+		s.Initialized = true
+		cmd := g.command(subdir, "rev-parse", "HEAD")
+		stdout, stderr, err := dividedOutput(cmd)
+		if err != nil {
+			return nil, gitError(err, stderr)
+		}
+		s.Revision = strings.TrimSuffix(string(stdout), "\n")
+		cmd = g.statusCommand(subdir)
+		stdout, stderr, err = dividedOutput(cmd)
+		if err != nil {
+			return nil, gitError(err, stderr)
+		}
+		s.Dirty = len(stdout) != 0
+	}
+	if !g.recurseSubmodules {
+		return submodules, nil
+	}
+	var nested []Submodule
+	for _, s := range submodules {
+		if !s.Initialized {
+			continue
+		}
+		subs, err := g.Submodules(filepath.Join(root, filepath.FromSlash(s.Path)))
+		if err != nil {
+			return nil, err
+		}
+		for _, sub := range subs {
+			sub.Path = s.Path + "/" + sub.Path
+			nested = append(nested, sub)
+		}
+	}
+	return append(submodules, nested...), nil
+}
+
+// parseGitGitlinks parses submodule paths and recorded revisions from the output of
+// ls-files -z --stage.
+func parseGitGitlinks(out []byte) ([]Submodule, error) {
+	var submodules []Submodule
+	seen := make(map[string]bool) // A conflicted submodule has more than one stage.
+	for _, entry := range splitNUL(out) {
+		// E.g., "160000 7cafcd837844e784b526369c9bce262804aebc60 0\tvendor/lib".
+		tab := strings.IndexByte(entry, '\t')
+		if tab == -1 {
+			return nil, fmt.Errorf("unexpected ls-files entry: %q", entry)
+		}
+		fields := strings.Fields(entry[:tab])
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected ls-files entry: %q", entry)
+		}
+		path := entry[tab+1:]
+		if fields[0] != "160000" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		submodules = append(submodules, Submodule{Path: path, RecordedRevision: fields[1]})
+	}
+	return submodules, nil
+}
+
+// parseGitModules parses the output of config -z --get-regexp over .gitmodules
+// into a map of submodule path to URL.
+func parseGitModules(out []byte) map[string]string {
+	paths := make(map[string]string) // Submodule name -> path.
+	urls := make(map[string]string)  // Submodule name -> URL.
+	for _, entry := range splitNUL(out) {
+		// E.g., "submodule.lib.path\nvendor/lib".
+		nl := strings.IndexByte(entry, '\n')
+		if nl == -1 {
+			continue
+		}
+		key, value := entry[:nl], entry[nl+1:]
+		switch {
+		case strings.HasSuffix(key, ".path"):
+			paths[key[len("submodule."):len(key)-len(".path")]] = value
+		case strings.HasSuffix(key, ".url"):
+			urls[key[len("submodule."):len(key)-len(".url")]] = value
+		}
+	}
+	m := make(map[string]string)
+	for name, path := range paths {
+		m[path] = urls[name]
+	}
+	return m
+}
+
+// gitBranchFormat is the for-each-ref format parsed by parseGitBranches.
+const gitBranchFormat = "%(refname)\t%(objectname)\t%(upstream:short)\t%(upstream:track)\t%(committerdate:raw)"
+
+func (g git) Branches(dir string, defaultBranch string) ([]LocalBranch, error) {
+	if err := checkArg("branch", defaultBranch); err != nil {
+		return nil, err
+	}
+	forEachRefMerged := g.caps.forEachRefFilters
+	cmd := g.command(dir, "for-each-ref", "--format="+gitBranchFormat, "refs/heads")
+	stdout, stderr, err := dividedOutput(cmd)
+	if err != nil {
+		return nil, gitError(err, stderr)
+	}
+	branches, err := parseGitBranches(stdout)
+	if err != nil {
+		return nil, err
+	}
+
+	if forEachRefMerged {
+		cmd = g.command(dir, "for-each-ref", "--format=%(refname)", "--merged=refs/heads/"+defaultBranch, "refs/heads")
+	} else {
+		cmd = g.command(dir, "branch", "--merged="+defaultBranch)
+	}
+	stdout, stderr, err = dividedOutput(cmd)
+	if err != nil {
+		return nil, gitError(err, stderr)
+	}
+	var merged map[string]bool
+	if forEachRefMerged {
+		merged = parseGitRefNames(stdout)
+	} else {
+		merged = parseGitBranchList(stdout)
+	}
+	for i := range branches {
+		branches[i].Merged = merged[branches[i].Name]
+	}
+	return branches, nil
+}
+
+// gitCommitFormat is the log format parsed by parseGitLog. %S is the branch
+// that a commit was reached from, with --source.
+const gitCommitFormat = "%H%x00%S%x00%an <%ae>%x00%ct%x00%s"
+
+// UnpushedCommits implements VCS.UnpushedCommits. Commits are listed newest first,
+// and each one is reported once, on a branch that it was reached from.
+func (g git) UnpushedCommits(dir string, limit int) ([]Commit, error) {
+	args := []string{"-c", "log.showSignature=false", "log", "--no-color", "--source"}
+	if limit > 0 {
+		args = append(args, "--max-count="+strconv.Itoa(limit))
+	}
+	args = append(args, "--branches", "--not", "--remotes")
+	format := gitCommitFormat
+	if !g.caps.logSourceFormat {
+		format = strings.Replace(format, "%S", "", 1)
+	}
+	cmd := g.command(dir, append(args, "--format=tformat:"+format)...)
+	stdout, stderr, err := dividedOutput(cmd)
+	if err != nil {
+		return nil, gitError(err, stderr)
+	}
+	commits, err := parseGitLog(stdout)
+	if err != nil {
+		return nil, err
+	}
+	if !g.caps.logSourceFormat && len(commits) != 0 {
+		// Get the branches from oneline output, which includes them with --source.
+		// It lists the same commits, since the revisions are the same.
+		cmd := g.command(dir, append(args, "--oneline", "--no-decorate", "--no-abbrev-commit")...)
+		stdout, stderr, err := dividedOutput(cmd)
+		if err != nil {
+			return nil, gitError(err, stderr)
+		}
+		sources := parseGitLogSources(stdout)
+		for i := range commits {
+			commits[i].Branch = sources[commits[i].Revision]
+		}
+	}
+	for i := range commits {
+		commits[i].Branch = strings.TrimPrefix(commits[i].Branch, "refs/heads/")
+	}
+	return commits, nil
+}
+
+// parseGitLog parses the output of log --format=tformat:gitCommitFormat.
+func parseGitLog(out []byte) ([]Commit, error) {
+	var commits []Commit
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		// E.g., "7cafcd837844e784b526369c9bce262804aebc60\x00refs/heads/feature\x00Gopher <gopher@example.com>\x001500000000\x00Add feature.".
+		fields := strings.SplitN(line, "\x00", 5)
+		if len(fields) != 5 || len(fields[0]) != gitRevisionLength {
+			return nil, fmt.Errorf("unexpected log line: %q", line)
+		}
+		t, err := parseGitRawDate(fields[3])
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, Commit{
+			Revision: fields[0],
+			Author:   fields[2],
+			Time:     t,
+			Subject:  fields[4],
+			Branch:   fields[1],
+		})
+	}
+	return commits, nil
+}
+
+// parseGitLogSources parses the output of log --oneline --no-abbrev-commit --source,
+// and returns the branch that each commit was reached from.
+func parseGitLogSources(out []byte) map[string]string {
+	sources := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		// E.g., "7cafcd837844e784b526369c9bce262804aebc60\trefs/heads/feature Add feature.".
+		tab := strings.IndexByte(line, '\t')
+		if tab == -1 {
+			continue
+		}
+		source := line[tab+1:]
+		if sp := strings.IndexByte(source, ' '); sp != -1 {
+			source = source[:sp]
+		}
+		sources[line[:tab]] = source
+	}
+	return sources
+}
+
+// gitStashFormat is the stash list format parsed by parseGitStashList.
+const gitStashFormat = "%gd%x00%H%x00%P%x00%ct%x00%gs"
+
+func (g git) StashEntries(dir string) ([]StashEntry, error) {
+	cmd := g.command(dir, "stash", "list", "--format="+gitStashFormat)
+	stdout, stderr, err := dividedOutput(cmd)
+	if err != nil && gitNoWorkingTree(stderr) {
+		return nil, ErrNoWorkingTree
+	} else if err != nil {
+		return nil, gitError(err, stderr)
+	}
+	entries, commits, err := parseGitStashList(stdout)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range entries {
+		// A stash commit records the working tree on top of its first parent.
+		// Untracked files, if stashed, are recorded in a third parent.
+		cmd := g.command(dir, "diff-tree", "-z", "--no-commit-id", "--name-only", "-r", e.Base, commits[i][0])
+		stdout, stderr, err := dividedOutput(cmd)
+		if err != nil {
+			return nil, gitError(err, stderr)
+		}
+		files := splitNUL(stdout)
+		if len(commits[i]) == 4 {
+			cmd := g.command(dir, "ls-tree", "-z", "-r", "--name-only", commits[i][3])
+			stdout, stderr, err := dividedOutput(cmd)
+			if err != nil {
+				return nil, gitError(err, stderr)
+			}
+			files = append(files, splitNUL(stdout)...)
+		}
+		entries[i].Files = files
+	}
+	return entries, nil
+}
+
+// parseGitStashList parses the output of stash list --format=gitStashFormat.
+// For each entry, it also returns the stash commit followed by its parents.
+func parseGitStashList(out []byte) ([]StashEntry, [][]string, error) {
+	var entries []StashEntry
+	var commits [][]string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		// E.g., "stash@{0}\x00<stash>\x00<base> <index>\x001500000000\x00WIP on master: 7cafcd8 Add feature.".
+		fields := strings.Split(sc.Text(), "\x00")
+		if len(fields) != 5 {
+			return nil, nil, fmt.Errorf("unexpected stash list line: %q", sc.Text())
+		}
+		parents := strings.Fields(fields[2])
+		if len(parents) == 0 {
+			return nil, nil, fmt.Errorf("stash %s has no parents", fields[0])
+		}
+		t, err := parseGitRawDate(fields[3])
+		if err != nil {
+			return nil, nil, err
+		}
+		branch, message := parseGitStashSubject(fields[4])
+		entries = append(entries, StashEntry{
+			Name:    fields[0],
+			Branch:  branch,
+			Message: message,
+			Time:    t,
+			Base:    parents[0],
+		})
+		commits = append(commits, append([]string{fields[1]}, parents...))
+	}
+	return entries, commits, sc.Err()
+}
+
+// parseGitStashSubject parses the branch and message from a stash reflog subject,
+// e.g., "WIP on master: 7cafcd8 Add feature." or "On master: message".
+func parseGitStashSubject(subject string) (branch, message string) {
+	for _, prefix := range []string{"WIP on ", "On "} {
+		if !strings.HasPrefix(subject, prefix) {
+			continue
+		}
+		rest := subject[len(prefix):]
+		i := strings.Index(rest, ": ") // Branch names can't contain ':'.
+		if i == -1 {
+			break
+		}
+		branch, message = rest[:i], rest[i+len(": "):]
+		if branch == "(no branch)" {
+			branch = ""
+		}
+		return branch, message
+	}
+	return "", subject
+}
+
+// parseGitBranches parses the output of for-each-ref --format=gitBranchFormat.
+func parseGitBranches(out []byte) ([]LocalBranch, error) {
+	var branches []LocalBranch
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		// E.g., "refs/heads/master	7cafcd837844e784b526369c9bce262804aebc60	origin/master	[ahead 1, behind 2]	1500000000 +0200".
+		fields := strings.Split(sc.Text(), "\t")
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("unexpected for-each-ref line: %q", sc.Text())
+		}
+		b := LocalBranch{
+			Name:     strings.TrimPrefix(fields[0], "refs/heads/"),
+			Revision: fields[1],
+			Upstream: fields[2],
+		}
+		var err error
+		b.Ahead, b.Behind, b.Gone, err = parseGitTrack(fields[3])
+		if err != nil {
+			return nil, err
+		}
+		b.Time, err = parseGitRawDate(fields[4])
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, b)
+	}
+	return branches, sc.Err()
+}
+
+// parseGitTrack parses the value of %(upstream:track), e.g., "[ahead 1, behind 2]" or "[gone]".
+func parseGitTrack(track string) (ahead, behind int, gone bool, err error) {
+	if track == "" {
+		return 0, 0, false, nil
+	}
+	if !strings.HasPrefix(track, "[") || !strings.HasSuffix(track, "]") {
+		return 0, 0, false, fmt.Errorf("unexpected upstream track value: %q", track)
+	}
+	for _, part := range strings.Split(track[1:len(track)-1], ", ") {
+		switch {
+		case part == "gone":
+			gone = true
+		case strings.HasPrefix(part, "ahead "):
+			ahead, err = strconv.Atoi(part[len("ahead "):])
+		case strings.HasPrefix(part, "behind "):
+			behind, err = strconv.Atoi(part[len("behind "):])
+		default:
+			err = fmt.Errorf("unexpected upstream track value: %q", track)
+		}
+		if err != nil {
+			return 0, 0, false, err
+		}
+	}
+	return ahead, behind, gone, nil
+}
+
+// parseGitRawDate parses a date in git's raw format, e.g., "1500000000 +0200".
+// An empty date, as reported for refs that don't point to a commit, is the zero time.
+func parseGitRawDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	if i := strings.IndexByte(date, ' '); i != -1 {
+		date = date[:i]
+	}
+	sec, err := strconv.ParseInt(date, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unexpected date value: %v", err)
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// parseGitRefNames parses the output of for-each-ref --format=%(refname) over refs/heads
+// into a set of branch names.
+func parseGitRefNames(out []byte) map[string]bool {
+	names := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+		names[strings.TrimPrefix(line, "refs/heads/")] = true
+	}
+	return names
+}
+
+// parseGitBranchList parses the output of git branch into a set of branch names.
+func parseGitBranchList(out []byte) map[string]bool {
+	names := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		// E.g., "* master" or "  feature". Each line has a 2 character prefix.
+		if len(line) <= 2 || line[2] == '(' { // Skip "* (HEAD detached at 7cafcd8)" and similar.
+			continue
+		}
+		names[line[2:]] = true
+	}
+	return names
+}
+
+// parseGit28LsRemote parses the branch and revision from output of
+// ls-remote --symref. It returns errBranchNotFound if HEAD branch is not found.
+// This can happen if git server doesn't support --symref option.
+func parseGit28LsRemote(out []byte) (branch string, revision string, err error) {
+	if len(out) == 0 {
+		return "", "", errors.New("empty ls-remote output")
+	}
+	lines := strings.Split(string(out[:len(out)-1]), "\n")
+	for _, line := range lines {
+		parts := strings.SplitN(line, "\t", 2)
+		if parts[1] != "HEAD" {
+			continue
+		}
+		if strings.HasPrefix(parts[0], "ref: refs/heads/") {
+			// "ref: refs/heads/master	HEAD".
+			branch = parts[0][len("ref: refs/heads/"):]
+		} else {
+			// "7cafcd837844e784b526369c9bce262804aebc60	HEAD".
+			revision = parts[0]
+		}
+
+		if branch != "" && revision != "" {
+			return branch, revision, nil
+		}
+	}
+	switch {
+	case branch == "" && revision != "":
+		return "", revision, errBranchNotFound
+	default:
+		return "", "", errors.New("HEAD branch or revision not found in ls-remote output")
+	}
+}
+
+// errBranchNotFound is returned when parseGit28LsRemote can't find HEAD branch
+// in ls-remote --symref output. This can happen for git servers that don't support it.
+var errBranchNotFound = errors.New("HEAD branch not found in ls-remote output")
+
+// guessBranch makes a best effort guess of determining HEAD branch
+// from output of ls-remote where --symref option wasn't supported by git server.
+// There doesn't seem to be a fully reliable way of determining it (I'm happy to be proven wrong).
+//
+// It's used by remoteGit.RemoteBranchAndRevision as a fallback,
+// and it does same guessing logic as parseGit17LsRemote.
+func guessBranch(out []byte, revision string) (branch string, err error) {
+	if len(out) == 0 {
+		return "", errors.New("empty ls-remote output")
+	}
+	lines := strings.Split(string(out[:len(out)-1]), "\n")
+	for _, line := range lines {
+		// E.g., "7cafcd837844e784b526369c9bce262804aebc60	refs/heads/main".
+		revRef := strings.SplitN(line, "\t", 2)
+		rev, ref := revRef[0], revRef[1]
+		if rev != revision || ref == "HEAD" {
+			continue
+		}
+		// HACK: There may be more than one branch that matches; prefer "master" over all
+		//       others, but otherwise no choice but to pick a random one, since there does
+		//       not seem to be a way of finding it exactly (I'm happy to be proven wrong though).
+		//       Unfortunately some git servers still don't support --symref option.
+		if branch != "master" {
+			branch = ref[len("refs/heads/"):]
+		}
+	}
+	if branch == "" {
+		return "", errBranchNotFound
+	}
+	return branch, nil
+}
+
+// parseGit17Remote parses the fetch URL for "origin" remote, if it exists.
+func parseGit17Remote(out []byte) (url string, err error) {
+	if len(out) == 0 {
+		return "", errors.New("no origin remote")
+	}
+	lines := strings.Split(string(out[:len(out)-1]), "\n")
+	for _, line := range lines {
+		// E.g., "origin	https://github.com/shurcooL/vcsstate (fetch)".
+		nameURLKind := strings.Split(line, "\t")
+		name, urlKind := nameURLKind[0], nameURLKind[1]
+
+		if name != "origin" {
+			continue
+		}
+		if !strings.HasSuffix(urlKind, " (fetch)") {
+			continue
+		}
+		url := urlKind[:len(urlKind)-len(" (fetch)")]
+		return url, nil
+	}
+	return "", errors.New("no origin remote")
+}
+
+// parseGit17LsRemote parses the branch and revision from output of
+// ls-remote (without --symref option).
+func parseGit17LsRemote(out []byte) (branch string, revision string, err error) {
+	if len(out) == 0 {
+		return "", "", errors.New("empty ls-remote output")
+	}
+	lines := strings.Split(string(out[:len(out)-1]), "\n")
+	for _, line := range lines {
+		// E.g., "7cafcd837844e784b526369c9bce262804aebc60	refs/heads/main".
+		revisionReference := strings.Split(line, "\t")
+		rev, ref := revisionReference[0], revisionReference[1]
+
+		// This assumes HEAD comes first, before all other references.
+		if ref == "HEAD" {
+			revision = rev
+			continue
+		}
+
+		// HACK: There may be more than one branch that matches; prefer "master" over all
+		//       others, but otherwise no choice but to pick a random one, since there does
+		//       not seem to be a way of finding it exactly (I'm happy to be proven wrong though).
+		//       In git 2.8, can use --symref option to fix this, but unfortunately some
+		//       git servers still don't support that option.
+		if rev == revision && branch != "master" {
+			branch = ref[len("refs/heads/"):]
+		}
+	}
+	if branch == "" || revision == "" {
+		return "", "", errors.New("HEAD branch or revision not found in ls-remote output")
+	}
+	return branch, revision, nil
+}
@@ -2,8 +2,19 @@ package vcsstate
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/shurcooL/vcsstate/remoteurl"
+	"golang.org/x/tools/go/vcs"
 )
 
 func TestGuessBranch(t *testing.T) {
@@ -133,3 +144,703 @@ f93697607c2406ba00b57fe418f4101b4e447eb8	refs/heads/numbers
 		}
 	}
 }
+
+func TestParseGitBranches(t *testing.T) {
+	in := []byte("refs/heads/feature\t0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a\t\t\t1500000000 +0200\n" +
+		"refs/heads/master\t7cafcd837844e784b526369c9bce262804aebc60\torigin/master\t[ahead 1, behind 2]\t1500000100 -0700\n" +
+		"refs/heads/old\t67253a98dcf0dd3273ea58929d7aaaa781ef6d13\torigin/old\t[gone]\t1400000000 +0000\n")
+	want := []LocalBranch{
+		{Name: "feature", Revision: "0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a", Time: time.Unix(1500000000, 0)},
+		{Name: "master", Revision: "7cafcd837844e784b526369c9bce262804aebc60", Time: time.Unix(1500000100, 0), Upstream: "origin/master", Ahead: 1, Behind: 2},
+		{Name: "old", Revision: "67253a98dcf0dd3273ea58929d7aaaa781ef6d13", Time: time.Unix(1400000000, 0), Upstream: "origin/old", Gone: true},
+	}
+	got, err := parseGitBranches(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseGitTrack(t *testing.T) {
+	tests := []struct {
+		in                    string
+		wantAhead, wantBehind int
+		wantGone              bool
+		wantErr               bool
+	}{
+		{in: ""},
+		{in: "[ahead 3]", wantAhead: 3},
+		{in: "[behind 12]", wantBehind: 12},
+		{in: "[ahead 1, behind 2]", wantAhead: 1, wantBehind: 2},
+		{in: "[gone]", wantGone: true},
+		{in: "[sideways 1]", wantErr: true},
+		{in: "ahead 1", wantErr: true},
+	}
+	for _, test := range tests {
+		ahead, behind, gone, err := parseGitTrack(test.in)
+		if got, want := err != nil, test.wantErr; got != want {
+			t.Errorf("%q: got error %v, want error %v", test.in, err, want)
+			continue
+		}
+		if ahead != test.wantAhead || behind != test.wantBehind || gone != test.wantGone {
+			t.Errorf("%q: got %v, %v, %v, want %v, %v, %v", test.in, ahead, behind, gone, test.wantAhead, test.wantBehind, test.wantGone)
+		}
+	}
+}
+
+func TestParseGitBranchList(t *testing.T) {
+	in := []byte(`* (HEAD detached at 7cafcd8)
+  feature
+  master
+`)
+	want := map[string]bool{"feature": true, "master": true}
+	if got := parseGitBranchList(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseGitLog(t *testing.T) {
+	in := []byte("7cafcd837844e784b526369c9bce262804aebc60\x00refs/heads/feature\x00Gopher <gopher@example.com>\x001500000100\x00Add feature.\n" +
+		"0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a\x00\x00Gopher <gopher@example.com>\x001500000000\x00Initial commit.\n")
+	want := []Commit{
+		{Revision: "7cafcd837844e784b526369c9bce262804aebc60", Author: "Gopher <gopher@example.com>", Time: time.Unix(1500000100, 0), Subject: "Add feature.", Branch: "refs/heads/feature"},
+		{Revision: "0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a", Author: "Gopher <gopher@example.com>", Time: time.Unix(1500000000, 0), Subject: "Initial commit."},
+	}
+	got, err := parseGitLog(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	got, err = parseGitLog(nil)
+	if err != nil || len(got) != 0 {
+		t.Errorf("got %v, %v, want no commits", got, err)
+	}
+	if _, err := parseGitLog([]byte("gpg: Signature made ...\n")); err == nil {
+		t.Error("got no error for unexpected output")
+	}
+}
+
+func TestParseGitLogSources(t *testing.T) {
+	in := []byte("7cafcd837844e784b526369c9bce262804aebc60\trefs/heads/feature Add feature.\n" +
+		"0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a\tmaster Initial commit.\n")
+	want := map[string]string{
+		"7cafcd837844e784b526369c9bce262804aebc60": "refs/heads/feature",
+		"0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a": "master",
+	}
+	if got := parseGitLogSources(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGitUnpushedCommits(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	remote := tempGitRepo(t)
+	dir := filepath.Join(t.TempDir(), "clone")
+	gitRun(t, remote, "clone", "-q", remote, dir)
+	// The branch that sorts first has the oldest commits, so that the newest
+	// commits aren't simply the first ones in refname order.
+	gitRun(t, dir, "checkout", "-q", "-b", "a-old")
+	for i, c := range []struct{ branch, subject string }{
+		{"a-old", "old 1"},
+		{"a-old", "old 2"},
+		{"master", "new 1"},
+		{"master", "new 2"},
+	} {
+		gitRun(t, dir, "checkout", "-q", c.branch)
+		t.Setenv("GIT_COMMITTER_DATE", strconv.Itoa(1500000000+i)+" +0000")
+		gitRun(t, dir, "commit", "-q", "--allow-empty", "-m", c.subject)
+	}
+
+	for _, g := range testGits(t) {
+		commits, err := g.UnpushedCommits(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range commits {
+			got = append(got, c.Branch+": "+c.Subject)
+		}
+		if want := []string{"master: new 2", "master: new 1", "a-old: old 2", "a-old: old 1"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v: got %q, want %q", g.caps, got, want)
+		}
+		commits, err = g.UnpushedCommits(dir, 2)
+		if err != nil || len(commits) != 2 || commits[0].Subject != "new 2" || commits[1].Subject != "new 1" {
+			t.Errorf("%+v: with limit, got %+v, %v, want the 2 newest commits", g.caps, commits, err)
+		}
+	}
+}
+
+func TestParseGitStashList(t *testing.T) {
+	in := []byte("stash@{0}\x00f0aeabca5a127c4078abb8c8d64298b147264b55\x007cafcd837844e784b526369c9bce262804aebc60 0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a 67253a98dcf0dd3273ea58929d7aaaa781ef6d13\x001500000100\x00On feature: try something\n" +
+		"stash@{1}\x00f42bdee2ab503fed466739d8e8c55ae34fd9be45\x007cafcd837844e784b526369c9bce262804aebc60 f93697607c2406ba00b57fe418f4101b4e447eb8\x001500000000\x00WIP on master: 7cafcd8 Add feature.\n")
+	wantEntries := []StashEntry{
+		{Name: "stash@{0}", Branch: "feature", Message: "try something", Time: time.Unix(1500000100, 0), Base: "7cafcd837844e784b526369c9bce262804aebc60"},
+		{Name: "stash@{1}", Branch: "master", Message: "7cafcd8 Add feature.", Time: time.Unix(1500000000, 0), Base: "7cafcd837844e784b526369c9bce262804aebc60"},
+	}
+	wantCommits := [][]string{
+		{"f0aeabca5a127c4078abb8c8d64298b147264b55", "7cafcd837844e784b526369c9bce262804aebc60", "0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a", "67253a98dcf0dd3273ea58929d7aaaa781ef6d13"},
+		{"f42bdee2ab503fed466739d8e8c55ae34fd9be45", "7cafcd837844e784b526369c9bce262804aebc60", "f93697607c2406ba00b57fe418f4101b4e447eb8"},
+	}
+	entries, commits, err := parseGitStashList(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("got entries %+v, want %+v", entries, wantEntries)
+	}
+	if !reflect.DeepEqual(commits, wantCommits) {
+		t.Errorf("got commits %v, want %v", commits, wantCommits)
+	}
+}
+
+func TestParseGitStashSubject(t *testing.T) {
+	tests := []struct {
+		in          string
+		wantBranch  string
+		wantMessage string
+	}{
+		{in: "WIP on master: 7cafcd8 Add feature.", wantBranch: "master", wantMessage: "7cafcd8 Add feature."},
+		{in: "On feature/x: message: with colon", wantBranch: "feature/x", wantMessage: "message: with colon"},
+		{in: "WIP on (no branch): 7cafcd8 Add feature.", wantBranch: "", wantMessage: "7cafcd8 Add feature."},
+		{in: "autostash", wantBranch: "", wantMessage: "autostash"},
+	}
+	for _, test := range tests {
+		branch, message := parseGitStashSubject(test.in)
+		if branch != test.wantBranch || message != test.wantMessage {
+			t.Errorf("%q: got %q, %q, want %q, %q", test.in, branch, message, test.wantBranch, test.wantMessage)
+		}
+	}
+}
+
+func TestGitOperations(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	dir := tempGitRepo(t)
+	gitRun(t, dir, "checkout", "-q", "-b", "feature")
+	writeFile(t, filepath.Join(dir, "file"), "feature\n")
+	gitRun(t, dir, "commit", "-q", "-a", "-m", "feature")
+	gitRun(t, dir, "checkout", "-q", "master")
+	writeFile(t, filepath.Join(dir, "file"), "master\n")
+	gitRun(t, dir, "commit", "-q", "-a", "-m", "master")
+	master := strings.TrimSpace(gitRun(t, dir, "rev-parse", "master"))
+	feature := strings.TrimSpace(gitRun(t, dir, "rev-parse", "feature"))
+
+	for _, g := range testGits(t) {
+		ops, err := g.Operations(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ops) != 0 {
+			t.Errorf("got %+v, want no operations", ops)
+		}
+	}
+
+	gitTry(dir, "merge", "feature") // Expected to fail with a conflict.
+	g := testGit(t)
+	ops, err := g.Operations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Operation{{Kind: OperationMerge, Target: feature}}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("got %+v, want %+v", ops, want)
+	}
+	gitRun(t, dir, "merge", "--abort")
+
+	gitRun(t, dir, "checkout", "-q", "feature")
+	gitTry(dir, "rebase", "--merge", "master") // Expected to fail with a conflict.
+	ops, err = g.Operations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Operation{{Kind: OperationRebase, Branch: "feature", Target: master, Step: 1, Total: 1}}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("got %+v, want %+v", ops, want)
+	}
+}
+
+// testGit returns a git backend for the installed git binary.
+func testGit(t *testing.T) git {
+	t.Helper()
+	g, err := newGit(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// testGits returns git backends for the installed git binary, both with its
+// capabilities and without any, to also cover fallbacks for older versions of git.
+func testGits(t *testing.T) []git {
+	g := testGit(t)
+	return []git{g, {binary: g.binary}}
+}
+
+// tempGitRepo creates a git repository with a single commit on master branch
+// in a temporary directory.
+func tempGitRepo(t testing.TB) string {
+	dir := t.TempDir()
+	gitRun(t, dir, "init", "-q")
+	gitRun(t, dir, "symbolic-ref", "HEAD", "refs/heads/master")
+	writeFile(t, filepath.Join(dir, "file"), "initial\n")
+	gitRun(t, dir, "add", "file")
+	gitRun(t, dir, "commit", "-q", "-m", "initial")
+	return dir
+}
+
+// gitRun runs git with args in dir, and returns its output.
+func gitRun(t testing.TB, dir string, args ...string) string {
+	t.Helper()
+	out, err := gitTry(dir, args...)
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return out
+}
+
+// gitTry runs git with args in dir, and returns its output and error.
+func gitTry(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Gopher", "GIT_AUTHOR_EMAIL=gopher@example.com",
+		"GIT_COMMITTER_NAME=Gopher", "GIT_COMMITTER_EMAIL=gopher@example.com",
+		"GIT_CONFIG_NOSYSTEM=1", "HOME="+dir,
+	)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func writeFile(t testing.TB, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseGitGitlinks(t *testing.T) {
+	in := []byte("100644 0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a 0\t.gitmodules\x00" +
+		"160000 7cafcd837844e784b526369c9bce262804aebc60 0\tvendor/lib\x00" +
+		"100644 67253a98dcf0dd3273ea58929d7aaaa781ef6d13 0\tmain.go\x00")
+	want := []Submodule{{Path: "vendor/lib", RecordedRevision: "7cafcd837844e784b526369c9bce262804aebc60"}}
+	got, err := parseGitGitlinks(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseGitModules(t *testing.T) {
+	in := []byte("submodule.lib.path\nvendor/lib\x00submodule.lib.url\nhttps://example.com/lib.git\x00submodule.other.path\nother\x00")
+	want := map[string]string{"vendor/lib": "https://example.com/lib.git", "other": ""}
+	if got := parseGitModules(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGitSubmodules(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	inner, lib, top := tempGitRepo(t), tempGitRepo(t), tempGitRepo(t)
+	gitRun(t, lib, "-c", "protocol.file.allow=always", "submodule", "add", "-q", inner, "inner")
+	gitRun(t, lib, "commit", "-q", "-m", "add inner")
+	gitRun(t, top, "-c", "protocol.file.allow=always", "submodule", "add", "-q", lib, "vendor/lib")
+	gitRun(t, top, "commit", "-q", "-m", "add lib")
+	gitRun(t, top, "-c", "protocol.file.allow=always", "submodule", "update", "-q", "--init", "--recursive")
+	writeFile(t, filepath.Join(top, "vendor", "lib", "inner", "file"), "changed\n")
+	libRev := strings.TrimSpace(gitRun(t, lib, "rev-parse", "HEAD"))
+	innerRev := strings.TrimSpace(gitRun(t, inner, "rev-parse", "HEAD"))
+
+	want := []Submodule{
+		{Path: "vendor/lib", URL: lib, RecordedRevision: libRev, Revision: libRev, Initialized: true, Dirty: true},
+	}
+	v, err := NewVCS(vcs.ByCmd("git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := v.Submodules(top); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, %v, want %+v", got, err, want)
+	}
+
+	want = append(want, Submodule{Path: "vendor/lib/inner", URL: inner, RecordedRevision: innerRev, Revision: innerRev, Initialized: true, Dirty: true})
+	v, err = NewVCS(vcs.ByCmd("git"), RecurseSubmodules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := v.Submodules(top); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("recursive: got %+v, %v, want %+v", got, err, want)
+	}
+}
+
+func TestParseGitWorktreeList(t *testing.T) {
+	in := []byte(`worktree /home/gopher/repo
+HEAD 7cafcd837844e784b526369c9bce262804aebc60
+branch refs/heads/master
+
+worktree /home/gopher/repo-feature
+HEAD 0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a
+branch refs/heads/feature
+locked on removable drive
+
+worktree /tmp/repo-detached
+HEAD 67253a98dcf0dd3273ea58929d7aaaa781ef6d13
+detached
+prunable gitdir file points to non-existent location
+
+`)
+	want := []Worktree{
+		{Path: "/home/gopher/repo", Revision: "7cafcd837844e784b526369c9bce262804aebc60", Branch: "master"},
+		{Path: "/home/gopher/repo-feature", Revision: "0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a", Branch: "feature", Locked: true, LockReason: "on removable drive"},
+		{Path: "/tmp/repo-detached", Revision: "67253a98dcf0dd3273ea58929d7aaaa781ef6d13", Prunable: true},
+	}
+	got, err := parseGitWorktreeList(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestGitLinkedWorktree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	dir := tempGitRepo(t)
+	linked := filepath.Join(t.TempDir(), "linked")
+	gitRun(t, dir, "worktree", "add", "-q", "-b", "feature", linked)
+	writeFile(t, filepath.Join(linked, "file"), "changed\n")
+
+	g := testGit(t)
+	if info, err := g.Info(dir); err != nil || info.LinkedWorktree {
+		t.Errorf("main worktree: got %+v, %v, want not linked", info, err)
+	}
+	if info, err := g.Info(linked); err != nil || !info.LinkedWorktree {
+		t.Errorf("linked worktree: got %+v, %v, want linked", info, err)
+	}
+	if branch, err := g.Branch(linked); err != nil || branch != "feature" {
+		t.Errorf("got branch %q, %v, want %q", branch, err, "feature")
+	}
+	if status, err := g.Status(linked); err != nil || status != " M file\n" {
+		t.Errorf("got status %q, %v, want %q", status, err, " M file\n")
+	}
+
+	v, err := NewVCS(vcs.ByCmd("git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lister, ok := v.(WorktreeLister)
+	if !ok {
+		t.Fatal("git VCS doesn't implement WorktreeLister")
+	}
+	worktrees, err := lister.Worktrees(linked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(worktrees) != 2 {
+		t.Fatalf("got %v worktrees, want 2", len(worktrees))
+	}
+	if got := worktrees[0]; got.Branch != "master" || got.Dirty {
+		t.Errorf("main worktree: got %+v, want clean master", got)
+	}
+	if got := worktrees[1]; got.Branch != "feature" || !got.Dirty {
+		t.Errorf("linked worktree: got %+v, want dirty feature", got)
+	}
+}
+
+func TestGitWorktreesMissing(t *testing.T) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git binary not available")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("fake git is a shell script")
+	}
+	dir := tempGitRepo(t)
+	missing := filepath.Join(t.TempDir(), "missing")
+	gitRun(t, dir, "worktree", "add", "-q", "-b", "feature", missing)
+	if err := os.RemoveAll(missing); err != nil {
+		t.Fatal(err)
+	}
+
+	// Git older than 2.31 doesn't report prunable worktrees,
+	// so hide them from the output of the installed one.
+	oldGit := filepath.Join(t.TempDir(), "git")
+	writeScript(t, oldGit, `case "$*" in
+*"worktree list"*) out=$(`+shellQuote(gitPath)+` "$@") || exit; printf '%s\n\n' "$out" | grep -v '^prunable' ;;
+*) exec `+shellQuote(gitPath)+` "$@" ;;
+esac
+`)
+	for _, binary := range []string{gitPath, oldGit} {
+		g, err := newGit(Options{Binary: binary})
+		if err != nil {
+			t.Fatal(err)
+		}
+		worktrees, err := g.Worktrees(dir)
+		if err != nil {
+			t.Errorf("%s: %v", binary, err)
+			continue
+		}
+		if len(worktrees) != 2 || !worktrees[1].Prunable || worktrees[1].Dirty {
+			t.Errorf("%s: got %+v, want the missing worktree prunable", binary, worktrees)
+		}
+	}
+}
+
+func TestGitURLRewrites(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	dir := tempGitRepo(t)
+	gitRun(t, dir, "config", "--add", "url.git@GitHub.com:.insteadOf", "https://github.com/")
+	gitRun(t, dir, "config", "--add", "url.git@GitHub.com:.insteadOf", "gh:")
+	// Rules injected through the process environment are ignored, like other configuration there.
+	t.Setenv("GIT_CONFIG_PARAMETERS", "'url.https://evil.example/.insteadof'='gh:'")
+
+	v, err := NewVCS(vcs.ByCmd("git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewriter, ok := v.(URLRewriter)
+	if !ok {
+		t.Fatal("git VCS doesn't implement URLRewriter")
+	}
+	rs, err := rewriter.URLRewrites(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := remoteurl.Rewrites{
+		{Base: "git@GitHub.com:", InsteadOf: "https://github.com/"},
+		{Base: "git@GitHub.com:", InsteadOf: "gh:"},
+	}
+	if !reflect.DeepEqual(rs, want) {
+		t.Errorf("got %+v, want %+v", rs, want)
+	}
+	u, err := rs.Parse("gh:foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := u.Canonical(), "github.com/foo/bar"; got != want {
+		t.Errorf("got canonical %q, want %q", got, want)
+	}
+
+	// No rules.
+	if rs, err := rewriter.URLRewrites(tempGitRepo(t)); err != nil || len(rs) != 0 {
+		t.Errorf("got %+v, %v, want no rules", rs, err)
+	}
+}
+
+func TestGitBareMirror(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	dir := tempGitRepo(t)
+	mirror := filepath.Join(t.TempDir(), "mirror.git")
+	gitRun(t, dir, "clone", "-q", "--mirror", dir, mirror)
+
+	g := testGit(t)
+	if info, err := g.Info(mirror); err != nil || !info.Bare || !info.Mirror {
+		t.Errorf("got %+v, %v, want bare mirror", info, err)
+	}
+	if _, err := g.Status(mirror); err != ErrNoWorkingTree {
+		t.Errorf("Status: got error %v, want ErrNoWorkingTree", err)
+	}
+	if _, err := g.Stash(mirror); err != ErrNoWorkingTree {
+		t.Errorf("Stash: got error %v, want ErrNoWorkingTree", err)
+	}
+	if _, err := g.Submodules(mirror); err != ErrNoWorkingTree {
+		t.Errorf("Submodules: got error %v, want ErrNoWorkingTree", err)
+	}
+	if rev, err := g.LocalRevision(mirror, "master"); err != nil || len(rev) != gitRevisionLength {
+		t.Errorf("LocalRevision: got %q, %v", rev, err)
+	}
+	if url, err := g.RemoteURL(mirror); err != nil || url != dir {
+		t.Errorf("RemoteURL: got %q, %v, want %q", url, err, dir)
+	}
+
+	if _, ok := VCS(g).(MirrorStatusReporter); !ok {
+		t.Fatal("git VCS doesn't implement MirrorStatusReporter")
+	}
+	if status, err := g.MirrorStatus(mirror); err != nil || len(status.Stale) != 0 {
+		t.Errorf("got %+v, %v, want up to date", status, err)
+	}
+	gitRun(t, dir, "commit", "-q", "--allow-empty", "-m", "new")
+	gitRun(t, dir, "branch", "new")
+	status, err := g.MirrorStatus(mirror)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"refs/heads/master", "refs/heads/new"}; !reflect.DeepEqual(status.Stale, want) {
+		t.Errorf("got stale %v, want %v", status.Stale, want)
+	}
+}
+
+func TestGitShallowPartial(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	dir := tempGitRepo(t)
+	initial := strings.TrimSpace(gitRun(t, dir, "rev-parse", "HEAD"))
+	gitRun(t, dir, "commit", "-q", "--allow-empty", "-m", "second")
+	shallow := filepath.Join(t.TempDir(), "shallow")
+	gitRun(t, dir, "clone", "-q", "--depth=1", "file://"+dir, shallow)
+	head := strings.TrimSpace(gitRun(t, shallow, "rev-parse", "HEAD"))
+
+	for _, g := range testGits(t) {
+		if info, err := g.Info(shallow); err != nil || !info.Shallow || info.Partial {
+			t.Errorf("got %+v, %v, want shallow", info, err)
+		}
+		if ok, err := g.Contains(shallow, head, "master"); err != nil || !ok {
+			t.Errorf("Contains(head): got %v, %v, want true", ok, err)
+		}
+		if _, err := g.Contains(shallow, initial, "master"); err != ErrHistoryTruncated {
+			t.Errorf("Contains(initial): got error %v, want ErrHistoryTruncated", err)
+		}
+		if _, err := g.RemoteContains(shallow, initial, "master"); err != ErrHistoryTruncated {
+			t.Errorf("RemoteContains(initial): got error %v, want ErrHistoryTruncated", err)
+		}
+		if ok, err := g.Contains(dir, initial, "master"); err != nil || !ok {
+			t.Errorf("Contains(initial) in full clone: got %v, %v, want true", ok, err)
+		}
+	}
+
+	gitRun(t, dir, "config", "uploadpack.allowFilter", "true")
+	partial := filepath.Join(t.TempDir(), "partial")
+	gitRun(t, dir, "clone", "-q", "--filter=blob:none", "file://"+dir, partial)
+	if info, err := testGit(t).Info(partial); err != nil || info.Shallow || !info.Partial {
+		t.Errorf("got %+v, %v, want partial", info, err)
+	}
+	if ok, err := testGit(t).Contains(partial, initial, "master"); err != nil || !ok {
+		t.Errorf("Contains(initial) in partial clone: got %v, %v, want true", ok, err)
+	}
+}
+
+func TestParseGitVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want gitVersion
+	}{
+		{"git version 1.7.1\n", gitVersion{1, 7, 1}},
+		{"git version 2.39.3 (Apple Git-146)\n", gitVersion{2, 39, 3}},
+		{"git version 2.20.1.windows.1\n", gitVersion{2, 20, 1}},
+		{"git version 2.40.0-rc1\n", gitVersion{2, 40, 0}},
+		{"git version 2.45\n", gitVersion{2, 45, 0}},
+	}
+	for _, tc := range tests {
+		got, err := parseGitVersion([]byte(tc.in))
+		if err != nil {
+			t.Errorf("parseGitVersion(%q): %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseGitVersion(%q): got %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+	for _, in := range []string{"", "hg version 4.0\n", "git version two\n", "git version 2\n"} {
+		if _, err := parseGitVersion([]byte(in)); err == nil {
+			t.Errorf("parseGitVersion(%q): got no error", in)
+		}
+	}
+}
+
+func TestGitCapabilities(t *testing.T) {
+	if got := (gitVersion{1, 7, 1}).capabilities(); got != (gitCapabilities{}) {
+		t.Errorf("1.7.1: got %+v, want none", got)
+	}
+	got := gitVersion{2, 8, 0}.capabilities()
+	want := gitCapabilities{remoteGetURL: true, forEachRefFilters: true, worktreeList: true, lsRemoteSymref: true}
+	if got != want {
+		t.Errorf("2.8.0: got %+v, want %+v", got, want)
+	}
+	if got := (gitVersion{2, 39, 3}).capabilities(); !got.noOptionalLocks {
+		t.Errorf("2.39.3: got %+v, want noOptionalLocks", got)
+	}
+}
+
+func TestGitBinary(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	if _, err := NewVCS(vcs.ByCmd("git"), Binary(filepath.Join(t.TempDir(), "no-such-git"))); err == nil {
+		t.Error("got no error for a missing git binary")
+	}
+	path, _ := exec.LookPath("git")
+	v, err := NewVCS(vcs.ByCmd("git"), Binary(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := v.(git); g.binary != path {
+		t.Errorf("got binary %q, want %q", g.binary, path)
+	}
+}
+
+func TestGitOptions(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".gitconfig"), "[user]\n\tname = Isolated\n")
+	v, err := NewVCS(vcs.ByCmd("git"), Home(home), NoSystemConfig(), Env("GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=user.email", "GIT_CONFIG_VALUE_0=isolated@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := v.(git)
+	for _, tc := range []struct{ key, want string }{
+		{"user.name", "Isolated"},
+		{"user.email", "isolated@example.com"},
+	} {
+		out, err := g.command(home, "config", tc.key).Output()
+		if err != nil {
+			t.Fatalf("git config %s: %v", tc.key, err)
+		}
+		if got := strings.TrimSpace(string(out)); got != tc.want {
+			t.Errorf("git config %s: got %q, want %q", tc.key, got, tc.want)
+		}
+	}
+	if got := g.command("", "version").Env; !containsString(got, "GIT_CONFIG_NOSYSTEM=1") || !containsString(got, "HOME="+home) {
+		t.Errorf("got environment %q, want GIT_CONFIG_NOSYSTEM=1 and HOME=%s", got, home)
+	}
+}
+
+func TestGitInheritedEnvironment(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	dir, other := tempGitRepo(t), tempGitRepo(t)
+	gitRun(t, other, "checkout", "-q", "-b", "other")
+	writeFile(t, filepath.Join(other, "file"), "modified\n")
+
+	// As if run from a hook in the other repository.
+	t.Setenv("GIT_DIR", filepath.Join(other, ".git"))
+	t.Setenv("GIT_WORK_TREE", other)
+	t.Setenv("GIT_INDEX_FILE", filepath.Join(other, ".git", "index"))
+
+	g := testGit(t)
+	if branch, err := g.Branch(dir); err != nil || branch != "master" {
+		t.Errorf("Branch: got %q, %v, want master", branch, err)
+	}
+	if status, err := g.Status(dir); err != nil || status != "" {
+		t.Errorf("Status: got %q, %v, want clean", status, err)
+	}
+
+	v, err := NewVCS(vcs.ByCmd("git"), AllowEnv("GIT_DIR", "GIT_WORK_TREE", "GIT_INDEX_FILE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch, err := v.Branch(dir); err != nil || branch != "other" {
+		t.Errorf("Branch with AllowEnv: got %q, %v, want other", branch, err)
+	}
+}
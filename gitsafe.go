@@ -0,0 +1,112 @@
+package vcsstate
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// gitSafeConfig is configuration that disables git features which run commands
+// specified in repository configuration. It's used in safe mode.
+var gitSafeConfig = []string{
+	"core.fsmonitor=false",
+	"core.hooksPath=" + os.DevNull,
+	"diff.external=",
+	"credential.helper=",
+	"protocol.ext.allow=never",
+	"core.gitProxy=",
+	"log.showSignature=false",
+	"gpg.program=gpg",
+	"gpg.openpgp.program=gpg",
+	"gpg.x509.program=gpgsm",
+	"gpg.ssh.program=ssh-keygen",
+}
+
+// gitSafeEnv returns environment variables that complement gitSafeConfig in safe mode.
+// The first core.gitProxy value that git reads wins, and repository configuration is read
+// before -c options, so the proxy command is disabled by setting an empty GIT_PROXY_COMMAND,
+// which takes precedence over configuration. An inherited GIT_PROXY_COMMAND is kept.
+func gitSafeEnv() []string {
+	if os.Getenv("GIT_PROXY_COMMAND") != "" {
+		return nil
+	}
+	return []string{"GIT_PROXY_COMMAND="}
+}
+
+// gitDriverConfig matches configuration of filter, diff and remote drivers
+// that specifies commands to run. Their names are arbitrary, so they have to be
+// looked up in the repository configuration to be overridden.
+const gitDriverConfig = `^(filter|diff|remote)\..*\.(clean|smudge|process|textconv|command|uploadpack|receivepack)$`
+
+// safeConfig returns git -c options that disable running commands specified in
+// the configuration of the repository at dir, for use in safe mode.
+func (g git) safeConfig(dir string) []string {
+	var args []string
+	for _, kv := range gitSafeConfig {
+		args = append(args, "-c", kv)
+	}
+	if dir == "" {
+		return args
+	}
+	cmd := exec.Command(g.binary, "config", "-z", "--get-regexp", gitDriverConfig)
+	cmd.Dir = dir
+	cmd.Env = g.env.environ()
+	out, err := cmd.Output()
+	if err != nil {
+		// No matching configuration, or not a repository. In the latter case,
+		// the command that's being run will fail too.
+		return args
+	}
+	for _, key := range parseGitConfigKeys(out) {
+		if strings.Contains(key, "=") {
+			// A key like this can't be overridden with -c, so make git
+			// refuse to run rather than risk running the driver.
+			return append(args, "-c", "=")
+		}
+		switch {
+		case strings.HasSuffix(key, ".uploadpack"):
+			args = append(args, "-c", key+"=git-upload-pack")
+		case strings.HasSuffix(key, ".receivepack"):
+			args = append(args, "-c", key+"=git-receive-pack")
+		default:
+			// An empty command makes git fail to run the driver, rather than run it.
+			args = append(args, "-c", key+"=")
+		}
+	}
+	return args
+}
+
+// parseGitConfigKeys parses the output of git config -z --get-regexp,
+// returning the configuration keys.
+func parseGitConfigKeys(out []byte) []string {
+	var keys []string
+	for _, entry := range splitNUL(out) {
+		if i := strings.IndexByte(entry, '\n'); i != -1 {
+			entry = entry[:i]
+		}
+		keys = append(keys, entry)
+	}
+	return keys
+}
+
+// gitUnsafeRepository reports whether stderr of a failed git command indicates
+// that git refused to use a repository owned by someone else, as controlled by
+// the safe.directory configuration, and returns the repository directory.
+func gitUnsafeRepository(stderr []byte) (dir string, ok bool) {
+	for _, prefix := range [...]string{
+		"fatal: detected dubious ownership in repository at '", // git 2.36+.
+		"fatal: unsafe repository ('",                          // git 2.35.2 and 2.35.3.
+	} {
+		i := bytes.Index(stderr, []byte(prefix))
+		if i == -1 {
+			continue
+		}
+		rest := stderr[i+len(prefix):]
+		if j := bytes.IndexByte(rest, '\''); j != -1 {
+			rest = rest[:j]
+		}
+		return string(rest), true
+	}
+	return "", false
+}
@@ -0,0 +1,250 @@
+package vcsstate
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// maliciousGitRepo creates a repository whose configuration runs commands
+// that create files in the returned directory, which is outside the repository.
+func maliciousGitRepo(t *testing.T) (dir, pwned string) {
+	t.Helper()
+	dir, pwned = tempGitRepo(t), t.TempDir()
+	writeFile(t, filepath.Join(dir, ".gitattributes"), "* filter=evil diff=evil\n")
+	gitRun(t, dir, "add", ".gitattributes")
+	gitRun(t, dir, "commit", "-q", "-m", "attributes")
+	touch := func(name string) string { return "touch " + filepath.Join(pwned, name) }
+	gitRun(t, dir, "config", "core.fsmonitor", touch("fsmonitor"))
+	gitRun(t, dir, "config", "filter.evil.clean", touch("clean")+"; cat")
+	gitRun(t, dir, "config", "filter.evil.process", touch("process"))
+	gitRun(t, dir, "config", "diff.evil.textconv", touch("textconv")+"; cat")
+	gitRun(t, dir, "config", "diff.external", touch("external"))
+	for _, hook := range []string{"post-index-change", "reference-transaction"} {
+		path := filepath.Join(dir, ".git", "hooks", hook)
+		writeFile(t, path, "#!/bin/sh\n"+touch(hook)+"\n")
+		if err := os.Chmod(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Modify the file while keeping its size, and make its modification time
+	// old, so that git has to compare its contents, running the clean filter.
+	path := filepath.Join(dir, "file")
+	writeFile(t, path, "changed\n")
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	return dir, pwned
+}
+
+func pwnedFiles(t *testing.T, pwned string) []string {
+	t.Helper()
+	fis, err := ioutil.ReadDir(pwned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+func TestGitSafeMode(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+
+	// Make sure the fixture does run commands when not in safe mode.
+	dir, pwned := maliciousGitRepo(t)
+	testGit(t).Status(dir) // The fsmonitor hook is not a valid one, so this fails after running it.
+	if len(pwnedFiles(t, pwned)) == 0 {
+		t.Fatal("malicious fixture didn't run any commands without safe mode")
+	}
+
+	dir, pwned = maliciousGitRepo(t)
+	v, err := NewVCS(vcs.ByCmd("git"), SafeMode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := v.Status(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := " M file\n"; status != want {
+		t.Errorf("Status: got %q, want %q", status, want)
+	}
+	if branch, err := v.Branch(dir); err != nil || branch != "master" {
+		t.Errorf("Branch: got %q, %v, want master", branch, err)
+	}
+	if _, err := v.LocalRevision(dir, "master"); err != nil {
+		t.Errorf("LocalRevision: %v", err)
+	}
+	if _, err := v.Stash(dir); err != nil {
+		t.Errorf("Stash: %v", err)
+	}
+	if _, err := v.Submodules(dir); err != nil {
+		t.Errorf("Submodules: %v", err)
+	}
+	if names := pwnedFiles(t, pwned); len(names) != 0 {
+		t.Errorf("commands from repository configuration ran in safe mode: %v", names)
+	}
+}
+
+func TestGitSafeModeGitProxy(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	t.Setenv("GIT_PROXY_COMMAND", "")
+	os.Unsetenv("GIT_PROXY_COMMAND") // Even an empty one disables core.gitProxy.
+	dir, pwned := tempGitRepo(t), t.TempDir()
+	proxy := filepath.Join(t.TempDir(), "proxy")
+	writeScript(t, proxy, "touch "+filepath.Join(pwned, "proxy")+"\nexit 1\n")
+	gitRun(t, dir, "remote", "add", "origin", "git://example.invalid/repo")
+	gitRun(t, dir, "config", "core.gitProxy", proxy)
+
+	// Make sure the fixture does run the proxy when not in safe mode.
+	testGit(t).RemoteBranchAndRevision(dir)
+	if len(pwnedFiles(t, pwned)) == 0 {
+		t.Fatal("malicious fixture didn't run the proxy without safe mode")
+	}
+
+	pwned = t.TempDir()
+	writeScript(t, proxy, "touch "+filepath.Join(pwned, "proxy")+"\nexit 1\n")
+	v, err := NewVCS(vcs.ByCmd("git"), SafeMode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := v.RemoteBranchAndRevision(dir); err == nil {
+		t.Error("RemoteBranchAndRevision: got no error for an unreachable remote")
+	}
+	if names := pwnedFiles(t, pwned); len(names) != 0 {
+		t.Errorf("core.gitProxy ran in safe mode: %v", names)
+	}
+}
+
+func TestGitSafeModeShowSignature(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	dir, pwned := tempGitRepo(t), t.TempDir()
+	writeFile(t, filepath.Join(dir, "file"), "changed\n")
+	gitRun(t, dir, "stash", "-q")
+	// Add a stash entry for a signed copy of the stash commit, so that git log
+	// verifies its signature with gpg.program.
+	commit := gitRun(t, dir, "cat-file", "commit", "stash@{0}")
+	i := strings.Index(commit, "\n\n")
+	signed := commit[:i] + "\ngpgsig -----BEGIN PGP SIGNATURE-----\n \n iQ==\n -----END PGP SIGNATURE-----" + commit[i:]
+	cmd := exec.Command("git", "hash-object", "-t", "commit", "-w", "--stdin")
+	cmd.Dir, cmd.Stdin = dir, strings.NewReader(signed)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, dir, "update-ref", "-m", "signed", "refs/stash", strings.TrimSpace(string(out)))
+	gpg := filepath.Join(t.TempDir(), "gpg")
+	gitRun(t, dir, "config", "log.showSignature", "true")
+	for _, key := range []string{"gpg.program", "gpg.openpgp.program"} {
+		gitRun(t, dir, "config", key, gpg)
+	}
+
+	// Make sure the fixture does run gpg when not in safe mode.
+	writeScript(t, gpg, "touch "+filepath.Join(pwned, "gpg")+"\nexit 1\n")
+	testGit(t).StashEntries(dir)
+	if len(pwnedFiles(t, pwned)) == 0 {
+		t.Fatal("malicious fixture didn't run gpg without safe mode")
+	}
+
+	pwned = t.TempDir()
+	writeScript(t, gpg, "touch "+filepath.Join(pwned, "gpg")+"\nexit 1\n")
+	v, err := NewVCS(vcs.ByCmd("git"), SafeMode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stash, err := v.Stash(dir); err != nil || stash == "" {
+		t.Errorf("Stash: got %q, %v, want stash entries", stash, err)
+	}
+	if names := pwnedFiles(t, pwned); len(names) != 0 {
+		t.Errorf("gpg.program ran in safe mode: %v", names)
+	}
+}
+
+func TestParseGitConfigKeys(t *testing.T) {
+	// git config -z --get-regexp '^(filter|diff)\..*\.(clean|textconv)$'
+	in := []byte("filter.evil.clean\ntouch pwned; cat\x00diff.Two Words.textconv\ncat\nmore\x00filter.empty.clean\x00")
+	got := parseGitConfigKeys(in)
+	want := []string{"filter.evil.clean", "diff.Two Words.textconv", "filter.empty.clean"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGitUnsafeRepository(t *testing.T) {
+	for _, tc := range []struct {
+		stderr string
+		dir    string
+		ok     bool
+	}{
+		{
+			stderr: "fatal: detected dubious ownership in repository at '/tmp/repo'\nTo add an exception for this directory, call:\n\n\tgit config --global --add safe.directory /tmp/repo\n",
+			dir:    "/tmp/repo",
+			ok:     true,
+		},
+		{
+			stderr: "fatal: unsafe repository ('/tmp/repo' is owned by someone else)\nTo add an exception for this directory, call:\n\n\tgit config --global --add safe.directory /tmp/repo\n",
+			dir:    "/tmp/repo",
+			ok:     true,
+		},
+		{
+			stderr: "warning: unrelated\nfatal: detected dubious ownership in repository at '/tmp/repo'\n",
+			dir:    "/tmp/repo",
+			ok:     true,
+		},
+		{
+			stderr: "fatal: not a git repository (or any of the parent directories): .git\n",
+		},
+	} {
+		dir, ok := gitUnsafeRepository([]byte(tc.stderr))
+		if dir != tc.dir || ok != tc.ok {
+			t.Errorf("gitUnsafeRepository(%q): got %q, %v, want %q, %v", tc.stderr, dir, ok, tc.dir, tc.ok)
+		}
+	}
+}
+
+func TestGitUnsafeRepositoryError(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("changing repository ownership requires root")
+	}
+	dir := tempGitRepo(t)
+	if err := filepath.Walk(dir, func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, 65534, 65534)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVCS(vcs.ByCmd("git"), Home(t.TempDir()), NoSystemConfig(), SafeMode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = v.Status(dir)
+	e, ok := err.(UnsafeRepositoryError)
+	if !ok {
+		t.Fatalf("got error %v, want UnsafeRepositoryError", err)
+	}
+	if e.Dir != dir {
+		t.Errorf("got Dir %q, want %q", e.Dir, dir)
+	}
+}
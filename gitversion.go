@@ -0,0 +1,106 @@
+package vcsstate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// gitVersion is a git binary version.
+type gitVersion struct {
+	major, minor, patch int
+}
+
+// atLeast reports whether v is major.minor or newer.
+func (v gitVersion) atLeast(major, minor int) bool {
+	return v.major > major || v.major == major && v.minor >= minor
+}
+
+// parseGitVersion parses the output of git --version. Vendor suffixes,
+// such as in "git version 2.39.3 (Apple Git-146)" or "git version 2.20.1.windows.1",
+// and release candidate suffixes, such as in "git version 2.40.0-rc1", are ignored.
+func parseGitVersion(out []byte) (gitVersion, error) {
+	s := strings.TrimSpace(string(out))
+	if !strings.HasPrefix(s, "git version ") {
+		return gitVersion{}, fmt.Errorf("unexpected git --version output: %q", out)
+	}
+	fields := strings.Fields(s[len("git version "):])
+	if len(fields) == 0 {
+		return gitVersion{}, fmt.Errorf("unexpected git --version output: %q", out)
+	}
+	var nums []int
+	for _, part := range strings.SplitN(fields[0], ".", 3) {
+		// Only use the leading digits, to skip suffixes like "-rc1" or ".windows.1".
+		digits := len(part) - len(strings.TrimLeft(part, "0123456789"))
+		n, err := strconv.Atoi(part[:digits])
+		if err != nil {
+			break
+		}
+		nums = append(nums, n)
+		if digits != len(part) {
+			break
+		}
+	}
+	if len(nums) < 2 {
+		return gitVersion{}, fmt.Errorf("unexpected git --version output: %q", out)
+	}
+	v := gitVersion{major: nums[0], minor: nums[1]}
+	if len(nums) == 3 {
+		v.patch = nums[2]
+	}
+	return v, nil
+}
+
+// gitCapabilities is the set of optional features supported by a git binary.
+// Fallbacks are used for features that aren't supported.
+type gitCapabilities struct {
+	remoteGetURL      bool // git remote get-url, added in 2.7.
+	forEachRefFilters bool // git for-each-ref --contains and --merged, added in 2.7.
+	worktreeList      bool // git worktree list --porcelain, added in 2.7.
+	lsRemoteSymref    bool // git ls-remote --symref, added in 2.8.
+	noOptionalLocks   bool // git --no-optional-locks, added in 2.15.
+	logSourceFormat   bool // git log --format=%S, added in 2.21.
+	endOfOptions      bool // --end-of-options, added in 2.24, and supported by rev-parse since 2.30.
+}
+
+// capabilities returns the capabilities of git version v.
+func (v gitVersion) capabilities() gitCapabilities {
+	return gitCapabilities{
+		remoteGetURL:      v.atLeast(2, 7),
+		forEachRefFilters: v.atLeast(2, 7),
+		worktreeList:      v.atLeast(2, 7),
+		lsRemoteSymref:    v.atLeast(2, 8),
+		noOptionalLocks:   v.atLeast(2, 15),
+		logSourceFormat:   v.atLeast(2, 21),
+		endOfOptions:      v.atLeast(2, 30),
+	}
+}
+
+// newGit creates a git backend for the git binary and environment specified in opts,
+// probing its version to determine its capabilities.
+func newGit(opts Options) (git, error) {
+	var set []string
+	if opts.NoSystemConfig {
+		set = append(set, "GIT_CONFIG_NOSYSTEM=1")
+	}
+	if opts.SafeMode {
+		set = append(set, gitSafeEnv()...)
+	}
+	g := git{binary: opts.Binary, env: opts.env(set...), safe: opts.SafeMode, allowTransports: opts.AllowTransports, credentials: opts.Credentials, ssh: opts.SSH, recurseSubmodules: opts.RecurseSubmodules}
+	if g.binary == "" {
+		g.binary = "git"
+	}
+	out, err := g.command("", "--version").Output()
+	if err != nil {
+		return git{}, err
+	}
+	v, err := parseGitVersion(out)
+	if err != nil {
+		return git{}, err
+	}
+	if !v.atLeast(1, 7) {
+		return git{}, fmt.Errorf("git support requires git binary version 1.7+, but you have: %q", out)
+	}
+	g.caps = v.capabilities()
+	return g, nil
+}
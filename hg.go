@@ -1,72 +1,528 @@
 package vcsstate
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/shurcooL/go/osutil"
 )
 
-var _, hgBinaryError = exec.LookPath("hg")
+// hg implements Mercurial support using an hg binary.
+type hg struct {
+	binary      string             // Name or path of the hg binary.
+	env         environment        // Environment to run the hg binary with.
+	safe        bool               // Safe mode, see Options.SafeMode.
+	credentials CredentialProvider // Provider of credentials for remotes, if any.
+	ssh         SSHOptions         // Options for the ssh command.
+
+	// opts are the options the backend was created with, which also apply
+	// to git subrepositories, except for Binary.
+	opts Options
+}
+
+// hgSafeModeVersion is the first version of hg that supports HGRCSKIPREPO,
+// which safe mode relies on. Older versions ignore it.
+var hgSafeModeVersion = [2]int{5, 4}
+
+// newHg creates an hg backend for the hg binary specified in opts.
+// It returns a usable backend along with an error if the binary can't be found.
+// In safe mode, it returns ErrSafeModeNotSupported if the binary is too old.
+func newHg(opts Options) (hg, error) {
+	var set []string
+	if opts.NoSystemConfig {
+		// Only read the user configuration, skipping system-wide hgrc files.
+		home := opts.Home
+		if home == "" {
+			home, _ = os.UserHomeDir()
+		}
+		set = append(set, "HGRCPATH="+filepath.Join(home, ".hgrc"))
+	}
+	if opts.SafeMode {
+		set = append(set, "HGRCSKIPREPO=1") // Don't read the repository's .hg/hgrc, which can enable hooks and extensions.
+	}
+	h := hg{binary: opts.Binary, env: opts.env(set...), safe: opts.SafeMode, credentials: opts.Credentials, ssh: opts.SSH, opts: opts}
+	if h.binary == "" {
+		h.binary = "hg"
+	}
+	if _, err := exec.LookPath(h.binary); err != nil {
+		return h, err
+	}
+	if opts.SafeMode {
+		out, err := h.command("", "version", "--quiet").Output()
+		if err != nil {
+			return h, err
+		}
+		major, minor, err := parseHgVersion(out)
+		if err != nil {
+			return h, err
+		}
+		if major < hgSafeModeVersion[0] || major == hgSafeModeVersion[0] && minor < hgSafeModeVersion[1] {
+			return h, ErrSafeModeNotSupported
+		}
+	}
+	return h, nil
+}
 
-type hg struct{}
+// parseHgVersion parses the major and minor version from the output of hg version --quiet,
+// like "Mercurial Distributed SCM (version 6.1.1)".
+func parseHgVersion(out []byte) (major, minor int, err error) {
+	s := strings.TrimSpace(string(out))
+	i := strings.Index(s, "(version ")
+	if i == -1 || !strings.HasSuffix(s, ")") {
+		return 0, 0, fmt.Errorf("unexpected hg version output: %q", out)
+	}
+	// Only use the leading digits, to skip suffixes like "rc" or "+12-abcdef".
+	parts := strings.SplitN(s[i+len("(version "):len(s)-1], ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("unexpected hg version output: %q", out)
+	}
+	var nums [2]int
+	for k := range nums {
+		digits := len(parts[k]) - len(strings.TrimLeft(parts[k], "0123456789"))
+		if nums[k], err = strconv.Atoi(parts[k][:digits]); err != nil {
+			return 0, 0, fmt.Errorf("unexpected hg version output: %q", out)
+		}
+	}
+	return nums[0], nums[1], nil
+}
 
-func (hg) Status(dir string) (string, error) {
-	cmd := exec.Command("hg", "status")
+// command returns a command that runs hg with args in dir.
+// HGPLAIN is set, so that user configuration such as ui.verbose,
+// color, or aliases can't affect the output.
+func (h hg) command(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command(h.binary, args...)
 	cmd.Dir = dir
+	cmd.Env = h.env.environ()
+	return cmd
+}
 
-	out, err := cmd.Output()
+// sshCommand returns the ssh command line used for remote operations in dir.
+// Unless the ssh binary is set in options, the configured ui.ssh is used, or else "ssh".
+// In safe mode, ui.ssh isn't used, like core.sshCommand for git. By default, ssh fails
+// rather than prompt for a password or passphrase, and it always fails rather than ask
+// about unknown host keys. Those options are only added for OpenSSH, and not to PuTTY's
+// plink, which doesn't accept them.
+func (h hg) sshCommand(dir string) string {
+	if h.ssh.Binary != "" {
+		return h.ssh.command(shellQuote(h.ssh.Binary))
+	}
+	if !h.safe {
+		out, err := h.command(dir, "config", "ui.ssh").Output()
+		if c := strings.TrimSpace(string(out)); err == nil && c != "" {
+			switch sshProgramName(sshProgram(c)) {
+			case "plink", "putty", "tortoiseplink":
+				return c
+			}
+			return h.ssh.command(c)
+		}
+	}
+	return h.ssh.command("ssh")
+}
+
+// remoteCommand is like command, but for commands that access the remote at remoteURL,
+// or the default path of the repository at dir if remoteURL is empty.
+// It fails rather than prompt for credentials or host key confirmation.
+//
+// A credential provided for the remote is written to a temporary configuration
+// file, so that it doesn't appear in command-line arguments. The returned cleanup
+// function removes it, and must be called after the command is done.
+func (h hg) remoteCommand(dir, remoteURL string, args ...string) (cmd *exec.Cmd, cleanup func(), err error) {
+	cmd = h.command(dir, append([]string{"--config", "ui.interactive=false", "--config", "ui.ssh=" + h.sshCommand(dir)}, args...)...)
+	if h.credentials == nil {
+		return cmd, func() {}, nil
+	}
+	if remoteURL == "" {
+		// If there's no default path, the command reports it.
+		remoteURL, _ = h.RemoteURL(dir)
+	}
+	cred, err := credentialFor(h.credentials, remoteURL)
+	if err != nil || cred == (Credential{}) {
+		return cmd, func() {}, err
+	}
+	tmp, err := ioutil.TempDir("", "vcsstate-hg-")
 	if err != nil {
-		return "", err
+		return nil, nil, err
+	}
+	cleanup = func() { os.RemoveAll(tmp) }
+	// Only use the credential for the remote's host, and not for another that it redirects to.
+	scheme, host := credentialScope(remoteURL)
+	auth := fmt.Sprintf("[auth]\nvcsstate.prefix = %s://%s\nvcsstate.schemes = %s\nvcsstate.username = %s\nvcsstate.password = %s\n", scheme, host, scheme, cred.Username, cred.Password)
+	env := osutil.Environ(cmd.Env)
+	var path, config string
+	switch rcPath, ok := getenv(env, "HGRCPATH"); {
+	case ok:
+		path, config = filepath.Join(tmp, "hgrc"), auth
+		env.Set("HGRCPATH", rcPath+string(os.PathListSeparator)+path)
+	case runtime.GOOS != "windows":
+		// Without HGRCPATH, hg reads its default configuration files, including ones
+		// relative to its installation, with $XDG_CONFIG_HOME/hg/hgrc last. Replace
+		// that one with a file that includes it and adds the credential.
+		path, config = filepath.Join(tmp, "hg", "hgrc"), "%include "+hgXDGConfig(env)+"\n"+auth
+		env.Set("XDG_CONFIG_HOME", tmp)
+	default:
+		// The user configuration files are found through the user profile directory,
+		// which can't be replaced without affecting other programs, so list the
+		// default configuration files, except for ones relative to hg's installation.
+		path, config = filepath.Join(tmp, "hgrc"), auth
+		env.Set("HGRCPATH", strings.Join(hgDefaultRCPath(env), string(os.PathListSeparator))+string(os.PathListSeparator)+path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		cleanup()
+		return nil, nil, err
 	}
-	return string(out), nil
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	cmd.Env = env
+	return cmd, cleanup, nil
 }
 
-func (hg) Branch(dir string) (string, error) {
-	/* TODO: Detect and report detached head mode. This currently returns "default" even when in detached head mode.
+// hgXDGConfig returns the path of the user configuration file that hg reads
+// from the XDG configuration directory on Unix, given env.
+func hgXDGConfig(env []string) string {
+	configHome, _ := getenv(env, "XDG_CONFIG_HOME")
+	if !filepath.IsAbs(configHome) {
+		home, _ := getenv(env, "HOME")
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "hg", "hgrc")
+}
 
-	Consider using `hg --debug identify` to resolve this. It might be helpful to detect detached head mode.
+// hgDefaultRCPath returns the configuration files hg reads on Windows when HGRCPATH
+// isn't set in env, except for ones relative to its installation.
+func hgDefaultRCPath(env []string) []string {
+	home, _ := getenv(env, "USERPROFILE")
+	return []string{filepath.Join(home, "mercurial.ini"), filepath.Join(home, ".hgrc")}
+}
 
-		hg --debug identify
+func (h hg) Info(dir string) (Info, error) {
+	return Info{}, nil
+}
 
-		Print a summary identifying the repository state at REV using one or two parent hash identifiers,
-		followed by a "+" if the working directory has uncommitted changes, the branch name (if not default),
-		a list of tags, and a list of bookmarks.
+func (h hg) Status(dir string) (string, error) {
+	cmd := h.command(dir, "status", "-T", "json")
 
-		65c40fd06bc50fdd6ded3a97b213f20d31428431
-		f5ac12b15e49095c60ae0acc6da0e28d47e2a29f+ tip
-		f5ac12b15e49095c60ae0acc6da0e28d47e2a29f tip
-	*/
-	cmd := exec.Command("hg", "branch")
-	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	files, err := parseHgStatus(out)
+	if err != nil {
+		return "", err
+	}
+	// Format the status the same way as plain hg status.
+	var buf bytes.Buffer
+	for _, f := range files {
+		fmt.Fprintf(&buf, "%s %s\n", f.Status, f.Path)
+	}
+	return buf.String(), nil
+}
+
+func (h hg) Branch(dir string) (string, error) {
+	// TODO: Detect and report detached head mode. This currently returns "default" even when in detached head mode.
+	cmd := h.command(dir, "identify", "-T", "json")
 
 	out, err := cmd.Output()
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimSuffix(string(out), "\n"), nil
+	id, err := parseHgIdentify(out)
+	if err != nil {
+		return "", err
+	}
+	return id.Branch, nil
+}
+
+func (h hg) Operations(dir string) ([]Operation, error) {
+	cmd := h.command(dir, "root")
+	root, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	hgDir := filepath.Join(strings.TrimSuffix(string(root), "\n"), ".hg")
+
+	var ops []Operation
+	// An uncommitted merge is one where the working directory has a second parent.
+	cmd = h.command(dir, "log", "--rev", "p2()", "-T", "json")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	p2, err := parseHgLog(out)
+	if err != nil {
+		return nil, err
+	}
+	if len(p2) != 0 {
+		ops = append(ops, Operation{Kind: OperationMerge, Target: p2[0].Node})
+	}
+	// Other operations leave state files in .hg while they're in progress.
+	if state, err := ioutil.ReadFile(filepath.Join(hgDir, "rebasestate")); err == nil {
+		ops = append(ops, parseHgRebaseState(state))
+	}
+	if _, err := os.Stat(filepath.Join(hgDir, "histedit-state")); err == nil {
+		ops = append(ops, Operation{Kind: OperationHistedit})
+	}
+	if _, err := os.Stat(filepath.Join(hgDir, "graftstate")); err == nil {
+		ops = append(ops, Operation{Kind: OperationGraft})
+	}
+	if _, err := os.Stat(filepath.Join(hgDir, "bisect.state")); err == nil {
+		ops = append(ops, Operation{Kind: OperationBisect})
+	}
+	if state, err := ioutil.ReadFile(filepath.Join(hgDir, "updatestate")); err == nil {
+		ops = append(ops, Operation{Kind: OperationUpdate, Target: strings.TrimSpace(string(state))})
+	}
+	return ops, nil
 }
 
 // hgRevisionLength is the length of a Mercurial revision hash.
 const hgRevisionLength = 40
 
-func (hg) LocalRevision(dir string, defaultBranch string) (string, error) {
-	cmd := exec.Command("hg", "--debug", "identify", "-i", "--rev", defaultBranch)
-	cmd.Dir = dir
+// LocalRevision returns the tip-most head of defaultBranch.
+// Heads can be used to check whether there are other heads.
+func (h hg) LocalRevision(dir string, defaultBranch string) (string, error) {
+	if err := checkArg("branch", defaultBranch); err != nil {
+		return "", err
+	}
+	// The branch is quoted, so that it's looked up as a name rather than parsed as a revset.
+	cmd := h.command(dir, "log", "--rev", hgQuote(defaultBranch), "--limit", "1", "-T", "json")
 
 	out, err := cmd.Output()
 	if err != nil {
 		return "", err
 	}
-	if len(out) < hgRevisionLength {
-		return "", fmt.Errorf("output length %v is shorter than %v", len(out), hgRevisionLength)
+	cs, err := parseHgLog(out)
+	if err != nil {
+		return "", err
+	}
+	if len(cs) != 1 || len(cs[0].Node) != hgRevisionLength {
+		return "", fmt.Errorf("unexpected hg log output: %q", out)
 	}
-	return string(out[:hgRevisionLength]), nil
+	return cs[0].Node, nil
 }
 
-func (hg) Stash(dir string) (string, error) {
-	cmd := exec.Command("hg", "shelve", "--list")
-	cmd.Dir = dir
+func (h hg) Heads(dir string) (Heads, error) {
+	cmd := h.command(dir, "log", "--rev", "head()", "-T", "json")
+	out, err := cmd.Output()
+	if err != nil {
+		return Heads{}, err
+	}
+	heads, err := parseHgLog(out)
+	if err != nil {
+		return Heads{}, err
+	}
+	cmd = h.command(dir, "log", "--rev", "head() and closed()", "-T", "json")
+	out, err = cmd.Output()
+	if err != nil {
+		return Heads{}, err
+	}
+	closed, err := parseHgLog(out)
+	if err != nil {
+		return Heads{}, err
+	}
+	cmd = h.command(dir, "log", "--rev", ".", "-T", "json")
+	out, err = cmd.Output()
+	if err != nil {
+		return Heads{}, err
+	}
+	parent, err := parseHgLog(out)
+	if err != nil {
+		return Heads{}, err
+	}
+	if len(parent) != 1 {
+		return Heads{}, fmt.Errorf("unexpected hg log output: %q", out)
+	}
+	return hgHeads(heads, closed, parent[0]), nil
+}
+
+// hgHeads computes Heads from all branch heads, the closed ones among them,
+// and the working directory parent.
+func hgHeads(heads, closed []hgChangeset, parent hgChangeset) Heads {
+	isClosed := make(map[string]bool)
+	for _, c := range closed {
+		isClosed[c.Node] = true
+	}
+	sort.SliceStable(heads, func(i, j int) bool { return heads[i].Rev > heads[j].Rev })
+
+	h := Heads{Parent: parent.Node}
+	index := make(map[string]int) // Branch name -> index in h.Branches.
+	for _, c := range heads {
+		i, ok := index[c.Branch]
+		if !ok {
+			i = len(h.Branches)
+			index[c.Branch] = i
+			h.Branches = append(h.Branches, BranchHeads{Branch: c.Branch})
+		}
+		if isClosed[c.Node] {
+			h.Branches[i].ClosedHeads = append(h.Branches[i].ClosedHeads, c.Node)
+		} else {
+			h.Branches[i].Heads = append(h.Branches[i].Heads, c.Node)
+		}
+	}
+	sort.Slice(h.Branches, func(i, j int) bool { return h.Branches[i].Branch < h.Branches[j].Branch })
+	for _, b := range h.Branches {
+		if b.Branch != parent.Branch {
+			continue
+		}
+		h.AtTip = len(b.Heads) != 0 && b.Heads[0] == parent.Node
+		h.NeedsMerge = len(b.Heads) > 1
+	}
+	return h
+}
+
+func (h hg) Evolution(dir string) (Evolution, error) {
+	cmd := h.command(dir, "log", "--rev", ".", "-T", "json")
+	out, err := cmd.Output()
+	if err != nil {
+		return Evolution{}, err
+	}
+	parent, err := parseHgLog(out)
+	if err != nil {
+		return Evolution{}, err
+	}
+	if len(parent) != 1 {
+		return Evolution{}, fmt.Errorf("unexpected hg log output: %q", out)
+	}
+
+	cmd = h.command(dir, "log", "--rev", "not public()", "-T", hgEvolutionTemplate)
+	stdout, stderr, err := dividedOutput(cmd)
+	if err != nil && bytes.Contains(stderr, []byte("instabilities")) {
+		// Mercurial older than 4.4 doesn't know about instabilities,
+		// so only report phases.
+		cmd = h.command(dir, "log", "--rev", "not public()", "-T", "{phase}\t\t\n")
+		stdout, err = cmd.Output()
+	}
+	if err != nil {
+		return Evolution{}, err
+	}
+	e := parseHgEvolution(stdout)
+	e.Phase = parent[0].Phase
+	return e, nil
+}
+
+func (h hg) Branches(dir string, defaultBranch string) ([]LocalBranch, error) {
+	if err := checkArg("branch", defaultBranch); err != nil {
+		return nil, err
+	}
+	cmd := h.command(dir, "branches", "-T", "json")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var named []struct {
+		Branch string `json:"branch"`
+		Node   string `json:"node"`
+	}
+	if err := json.Unmarshal(out, &named); err != nil {
+		return nil, err
+	}
+
+	cmd = h.command(dir, "bookmarks", "-T", "json")
+	out, err = cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var bookmarks []struct {
+		Bookmark string `json:"bookmark"`
+		Node     string `json:"node"`
+	}
+	if err := json.Unmarshal(out, &bookmarks); err != nil {
+		return nil, err
+	}
+
+	// Query commit times of all branch heads and bookmarks, and which of them
+	// are ancestors of the default branch, in one go.
+	cmd = h.command(dir, "log", "--rev", "head() or bookmark()", "-T", "json")
+	out, err = cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	heads, err := parseHgLog(out)
+	if err != nil {
+		return nil, err
+	}
+	cmd = h.command(dir, "log", "--rev", "(head() or bookmark()) and ::branch("+hgQuote(defaultBranch)+")", "-T", "json")
+	out, err = cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	merged, err := parseHgLog(out)
+	if err != nil {
+		return nil, err
+	}
+	times := make(map[string]time.Time)
+	for _, c := range heads {
+		times[c.Node] = c.time()
+	}
+	isMerged := make(map[string]bool)
+	for _, c := range merged {
+		isMerged[c.Node] = true
+	}
+
+	var branches []LocalBranch
+	for _, b := range named {
+		branches = append(branches, LocalBranch{
+			Name:     b.Branch,
+			Revision: b.Node,
+			Time:     times[b.Node],
+			Merged:   isMerged[b.Node],
+		})
+	}
+	for _, b := range bookmarks {
+		branches = append(branches, LocalBranch{
+			Name:     b.Bookmark,
+			Revision: b.Node,
+			Time:     times[b.Node],
+			Bookmark: true,
+			Merged:   isMerged[b.Node],
+		})
+	}
+	return branches, nil
+}
+
+// UnpushedCommits reports changesets in draft or secret phase.
+// Pushing to a publishing server makes changesets public. They're sorted
+// newest first, so that limit keeps the most recent ones, like for git.
+func (h hg) UnpushedCommits(dir string, limit int) ([]Commit, error) {
+	args := []string{"log", "--rev", "sort(not public(), -rev)", "-T", "json"}
+	if limit > 0 {
+		args = append(args, "--limit", strconv.Itoa(limit))
+	}
+	cmd := h.command(dir, args...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	cs, err := parseHgLog(out)
+	if err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	for _, c := range cs {
+		commits = append(commits, Commit{
+			Revision: c.Node,
+			Author:   c.User,
+			Time:     c.time(),
+			Subject:  strings.SplitN(c.Desc, "\n", 2)[0],
+			Branch:   c.Branch,
+		})
+	}
+	return commits, nil
+}
+
+func (h hg) Stash(dir string) (string, error) {
+	cmd := h.command(dir, "shelve", "--list")
 
 	stdout, stderr, err := dividedOutput(cmd)
 	switch {
@@ -74,82 +530,531 @@ func (hg) Stash(dir string) (string, error) {
 		return string(stdout), nil
 	case err == nil && len(stdout) == 0:
 		return "", nil
-	case err != nil && string(stderr) == "hg: unknown command 'shelve'\n":
+	case err != nil && strings.HasPrefix(string(stderr), "hg: unknown command 'shelve'\n"):
 		return "", nil
 	default:
 		return "", err
 	}
 }
 
-func (hg) Contains(dir string, revision string, defaultBranch string) (bool, error) {
-	cmd := exec.Command("hg", "log", "--branch", defaultBranch, "--rev", revision)
-	cmd.Dir = dir
+func (h hg) StashEntries(dir string) ([]StashEntry, error) {
+	cmd := h.command(dir, "shelve", "--list", "--quiet")
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err != nil && strings.HasPrefix(string(stderr), "hg: unknown command 'shelve'\n"):
+		return nil, ErrShelveNotEnabled
+	case err != nil:
+		return nil, err
+	}
+	names := strings.Fields(string(stdout)) // Shelve names can't contain whitespace.
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	cmd = h.command(dir, "root")
+	root, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var entries []StashEntry
+	for _, name := range names {
+		// Each shelve is stored as a patch in hg export format, whose header has the details we need.
+		patch, err := ioutil.ReadFile(filepath.Join(strings.TrimSuffix(string(root), "\n"), ".hg", "shelved", name+".patch"))
+		if err != nil {
+			return nil, err
+		}
+		e, err := parseHgPatch(patch)
+		if err != nil {
+			return nil, fmt.Errorf("shelve %s: %v", name, err)
+		}
+		e.Name = name
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (h hg) Submodules(dir string) ([]Submodule, error) {
+	cmd := h.command(dir, "root")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	root := strings.TrimSuffix(string(out), "\n")
+
+	hgsub, err := ioutil.ReadFile(filepath.Join(root, ".hgsub"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	hgsubstate, err := ioutil.ReadFile(filepath.Join(root, ".hgsubstate"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	subrepos := parseHgSub(hgsub)
+	revisions := parseHgSubstate(hgsubstate)
+
+	var (
+		submodules []Submodule
+		nested     []Submodule
+		g          *git // Backend for git subrepositories, created when one is found.
+	)
+	for _, sr := range subrepos {
+		s := Submodule{Path: sr.path, URL: sr.source, RecordedRevision: revisions[sr.path]}
+		subdir := filepath.Join(root, filepath.FromSlash(sr.path))
+		var subs []Submodule // Nested submodules, if recursing.
+		switch sr.kind {
+		case "hg":
+			if _, err := os.Stat(filepath.Join(subdir, ".hg")); err != nil {
+				break // Not initialized.
+			}
+			s.Initialized = true
+			cmd := h.command(subdir, "log", "--rev", ".", "-T", "json")
+			out, err := cmd.Output()
+			if err != nil {
+				return nil, err
+			}
+			cs, err := parseHgLog(out)
+			if err != nil {
+				return nil, err
+			}
+			if len(cs) == 1 {
+				s.Revision = cs[0].Node
+			}
+			status, err := h.Status(subdir)
+			if err != nil {
+				return nil, err
+			}
+			s.Dirty = status != ""
+			if h.opts.RecurseSubmodules {
+				subs, err = h.Submodules(subdir)
+				if err != nil {
+					return nil, err
+				}
+			}
+		case "git":
+			if _, err := os.Stat(filepath.Join(subdir, ".git")); err != nil {
+				break // Not initialized.
+			}
+			s.Initialized = true
+			if g == nil {
+				opts := h.opts
+				opts.Binary = "" // It's the hg binary.
+				gg, err := newGit(opts)
+				if err != nil {
+					return nil, err
+				}
+				g = &gg
+			}
+			cmd := g.command(subdir, "rev-parse", "HEAD")
+			stdout, stderr, err := dividedOutput(cmd)
+			if err != nil {
+				return nil, gitError(err, stderr)
+			}
+			s.Revision = strings.TrimSuffix(string(stdout), "\n")
+			cmd = g.statusCommand(subdir)
+			stdout, stderr, err = dividedOutput(cmd)
+			if err != nil {
+				return nil, gitError(err, stderr)
+			}
+			s.Dirty = len(stdout) != 0
+			if h.opts.RecurseSubmodules {
+				subs, err = g.Submodules(subdir)
+				if err != nil {
+					return nil, err
+				}
+			}
+		default:
+			// Other kinds of subrepositories, e.g., svn, only report whether they're checked out.
+			_, err := os.Stat(filepath.Join(subdir, "."+sr.kind))
+			s.Initialized = err == nil
+		}
+		for _, sub := range subs {
+			sub.Path = s.Path + "/" + sub.Path
+			nested = append(nested, sub)
+		}
+		submodules = append(submodules, s)
+	}
+	return append(submodules, nested...), nil
+}
+
+func (h hg) Contains(dir string, revision string, defaultBranch string) (bool, error) {
+	if err := checkArg("revision", revision); err != nil {
+		return false, err
+	}
+	if err := checkArg("branch", defaultBranch); err != nil {
+		return false, err
+	}
+	// The revision is quoted, so that it's looked up as a hash, number or name
+	// rather than parsed as a revset, which could match any changeset.
+	cmd := h.command(dir, "log", "--branch="+defaultBranch, "--rev", hgQuote(revision), "-T", "json")
 
 	stdout, stderr, err := dividedOutput(cmd)
 	switch {
-	case err == nil && len(stdout) != 0:
-		return true, nil // Non-zero output means this commit is indeed contained.
-	case err == nil && len(stdout) == 0:
-		return false, nil // Zero output means this commit is not contained.
-	case err != nil && string(stderr) == fmt.Sprintf("abort: unknown revision '%s'!\n", revision):
+	case err == nil:
+		cs, err := parseHgLog(stdout)
+		if err != nil {
+			return false, err
+		}
+		return len(cs) != 0, nil // Non-empty output means this commit is indeed contained.
+	case err != nil && strings.HasPrefix(string(stderr), fmt.Sprintf("abort: unknown revision '%s'", revision)):
 		return false, nil // Unknown revision error means this commit is not contained.
 	default:
 		return false, err
 	}
 }
 
-func (hg) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
+func (h hg) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
 	return false, errors.New("not implemented for hg")
 }
 
-func (hg) RemoteURL(dir string) (string, error) {
-	cmd := exec.Command("hg", "paths", "default")
-	cmd.Dir = dir
+func (h hg) RemoteURL(dir string) (string, error) {
+	cmd := h.command(dir, "paths", "-T", "json")
 
 	out, err := cmd.Output()
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimSuffix(string(out), "\n"), nil
+	paths, err := parseHgPaths(out)
+	if err != nil {
+		return "", err
+	}
+	url, ok := paths["default"]
+	if !ok {
+		return "", ErrNoRemote
+	}
+	return url, nil
 }
 
-func (hg) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
+func (h hg) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
 	// TODO: Query remote branch from actual remote; it's currently hardcoded to "default".
 	const defaultBranch = "default"
 
-	cmd := exec.Command("hg", "--debug", "identify", "-i", "--rev", defaultBranch, "default")
-	cmd.Dir = dir
+	cmd, cleanup, err := h.remoteCommand(dir, "", "identify", "--rev", defaultBranch, "-T", "json", "default")
+	if err != nil {
+		return "", "", err
+	}
+	defer cleanup()
 
-	out, err := cmd.Output()
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err != nil && bytes.HasPrefix(stderr, []byte("abort: repository default not found")):
+		return "", "", ErrNoRemote
+	case err != nil && hgAuthenticationFailed(stderr):
+		return "", "", AuthenticationError{Err: fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))}
+	case err != nil:
+		return "", "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	id, err := parseHgIdentify(stdout)
 	if err != nil {
 		return "", "", err
 	}
-	// Get the last line of output.
-	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") // lines will always contain at least one element.
-	return defaultBranch, lines[len(lines)-1], nil
+	return defaultBranch, id.revision(), nil
 }
 
-func (hg) CachedRemoteDefaultBranch() (string, error) {
+func (h hg) CachedRemoteDefaultBranch() (string, error) {
 	return "", fmt.Errorf("not implemented for hg, just use NoRemoteDefaultBranch")
 }
 
-func (hg) NoRemoteDefaultBranch() string {
+func (h hg) NoRemoteDefaultBranch() string {
 	return "default"
 }
 
-type remoteHg struct{}
+type remoteHg struct{ hg hg }
 
-func (remoteHg) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
+func (r remoteHg) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
 	// TODO: Query remote branch from actual remote; it's currently hardcoded to "default".
 	const defaultBranch = "default"
 
-	cmd := exec.Command("hg", "--debug", "identify", "-i", "--rev", defaultBranch, remoteURL)
+	if err := checkRemoteURL(remoteURL, nil); err != nil {
+		return "", "", err
+	}
+	cmd, cleanup, err := r.hg.remoteCommand("", remoteURL, "identify", "--rev", defaultBranch, "-T", "json", "--", remoteURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer cleanup()
 
-	out, err := cmd.Output()
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err != nil && hgAuthenticationFailed(stderr):
+		return "", "", AuthenticationError{Err: fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))}
+	case err != nil:
+		return "", "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	id, err := parseHgIdentify(stdout)
 	if err != nil {
 		return "", "", err
 	}
-	// Get the last line of output.
-	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") // lines will always contain at least one element.
-	return defaultBranch, lines[len(lines)-1], nil
+	return defaultBranch, id.revision(), nil
+}
+
+// hgAuthenticationFailed reports whether stderr of a failed hg remote command
+// indicates that authenticating with the remote failed, or would have required
+// a prompt, such as for a password or to accept an unknown ssh host key.
+func hgAuthenticationFailed(stderr []byte) bool {
+	for _, s := range [...]string{
+		"abort: http authorization required",
+		"abort: authorization failed",
+		"abort: response expected", // A prompt, such as for a password, when not interactive.
+		"Permission denied (",      // ssh, e.g., "Permission denied (publickey,password)."
+		"Host key verification failed.",
+	} {
+		if bytes.Contains(stderr, []byte(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hgStatusEntry is a file with outstanding status, as reported by hg status -T json.
+type hgStatusEntry struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+// parseHgStatus parses the output of hg status -T json.
+func parseHgStatus(out []byte) ([]hgStatusEntry, error) {
+	var files []hgStatusEntry
+	err := json.Unmarshal(out, &files)
+	return files, err
+}
+
+// hgIdentify is the output of hg identify -T json.
+type hgIdentify struct {
+	Branch string `json:"branch"`
+	ID     string `json:"id"`   // Revision, which isn't abbreviated in JSON output.
+	Node   string `json:"node"` // Full revision. Older versions of hg don't report it for remote repositories.
+}
+
+// revision returns the full revision that was identified.
+func (id hgIdentify) revision() string {
+	if id.Node != "" {
+		return id.Node
+	}
+	return id.ID
+}
+
+// parseHgIdentify parses the output of hg identify -T json.
+func parseHgIdentify(out []byte) (hgIdentify, error) {
+	var ids []hgIdentify
+	if err := json.Unmarshal(out, &ids); err != nil {
+		return hgIdentify{}, err
+	}
+	if len(ids) != 1 {
+		return hgIdentify{}, fmt.Errorf("unexpected hg identify output: %q", out)
+	}
+	return ids[0], nil
+}
+
+// parseHgPaths parses the output of hg paths -T json into a map of name to URL.
+func parseHgPaths(out []byte) (map[string]string, error) {
+	var paths []struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	}
+	if err := json.Unmarshal(out, &paths); err != nil {
+		return nil, err
+	}
+	m := make(map[string]string)
+	for _, p := range paths {
+		m[p.Name] = p.URL
+	}
+	return m, nil
+}
+
+// hgChangeset is a changeset, as reported by hg log -T json.
+type hgChangeset struct {
+	Rev    int       `json:"rev"`
+	Node   string    `json:"node"`
+	Branch string    `json:"branch"`
+	Phase  string    `json:"phase"`
+	User   string    `json:"user"`
+	Desc   string    `json:"desc"`
+	Date   []float64 `json:"date"` // Unix time and timezone offset.
+}
+
+// time returns the commit time of c.
+func (c hgChangeset) time() time.Time {
+	if len(c.Date) == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(c.Date[0]), 0)
+}
+
+// parseHgLog parses the output of hg log -T json.
+func parseHgLog(out []byte) ([]hgChangeset, error) {
+	var cs []hgChangeset
+	err := json.Unmarshal(out, &cs)
+	return cs, err
+}
+
+// hgEvolutionTemplate is a template that prints the phase, obsolete flag
+// and instabilities of a changeset, separated by tabs.
+const hgEvolutionTemplate = "{phase}\t{obsolete}\t{instabilities}\n"
+
+// parseHgEvolution parses hg log output formatted with hgEvolutionTemplate.
+// It leaves Phase unset.
+func parseHgEvolution(out []byte) Evolution {
+	var e Evolution
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		switch fields[0] {
+		case "draft":
+			e.Draft++
+		case "secret":
+			e.Secret++
+		}
+		if fields[1] != "" {
+			e.Obsolete++
+		}
+		for _, instability := range strings.Fields(fields[2]) {
+			switch instability {
+			case "orphan":
+				e.Orphan++
+			case "phase-divergent":
+				e.PhaseDivergent++
+			case "content-divergent":
+				e.ContentDivergent++
+			}
+		}
+	}
+	return e
+}
+
+// hgQuote quotes s for use as a string literal in a Mercurial revset.
+func hgQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// parseHgPatch parses a patch in hg export format, as used for storing shelves.
+func parseHgPatch(patch []byte) (StashEntry, error) {
+	lines := strings.Split(string(patch), "\n")
+	if len(lines) == 0 || lines[0] != "# HG changeset patch" {
+		return StashEntry{}, errors.New("not an hg patch")
+	}
+	e := StashEntry{Branch: "default"} // Branch header is omitted for default branch.
+	var message []string
+	inHeader := true
+	for _, line := range lines[1:] {
+		switch {
+		case inHeader && strings.HasPrefix(line, "# Date "):
+			// E.g., "# Date 1500000000 -7200".
+			fields := strings.Fields(line[len("# Date "):])
+			if len(fields) == 0 {
+				return StashEntry{}, fmt.Errorf("unexpected patch header: %q", line)
+			}
+			sec, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				return StashEntry{}, fmt.Errorf("unexpected patch header: %q", line)
+			}
+			e.Time = time.Unix(int64(sec), 0)
+		case inHeader && strings.HasPrefix(line, "# Branch "):
+			e.Branch = line[len("# Branch "):]
+		case inHeader && strings.HasPrefix(line, "# Parent "):
+			if e.Base == "" { // Only the first parent is the base.
+				e.Base = strings.TrimSpace(line[len("# Parent "):])
+			}
+		case inHeader && strings.HasPrefix(line, "#"):
+			// Other header lines, e.g., "# User" and "# Node ID", are not needed.
+		case strings.HasPrefix(line, "diff --git a/"):
+			inHeader = false
+			// E.g., "diff --git a/path/file.go b/path/file.go".
+			if i := strings.LastIndex(line, " b/"); i != -1 {
+				e.Files = append(e.Files, line[i+len(" b/"):])
+			}
+		case inHeader:
+			inHeader = false
+			message = append(message, line)
+		case len(e.Files) == 0:
+			message = append(message, line)
+		}
+	}
+	e.Message = strings.TrimSpace(strings.Join(message, "\n"))
+	return e, nil
+}
+
+// parseHgRebaseState parses the contents of .hg/rebasestate.
+func parseHgRebaseState(state []byte) Operation {
+	// The first lines are the original working directory parent, the destination,
+	// and rebase options, followed by one "source:result" line per changeset being rebased.
+	// Changesets not yet rebased have a result of "-1" (or null revision in older versions).
+	op := Operation{Kind: OperationRebase}
+	lines := strings.Split(strings.TrimSuffix(string(state), "\n"), "\n")
+	if len(lines) >= 2 {
+		op.Target = lines[1]
+	}
+	for _, line := range lines {
+		i := strings.IndexByte(line, ':')
+		if i == -1 || len(line[:i]) != hgRevisionLength {
+			continue
+		}
+		op.Total++
+		if result := line[i+1:]; result != "-1" && result != strings.Repeat("0", hgRevisionLength) {
+			op.Step++
+		}
+	}
+	if op.Step < op.Total {
+		op.Step++ // Step is the changeset currently being rebased.
+	}
+	return op
+}
+
+// hgSubrepo is a subrepository, as configured in .hgsub.
+type hgSubrepo struct {
+	path   string
+	source string
+	kind   string // "hg", "git" or "svn".
+}
+
+// parseHgSub parses the contents of .hgsub.
+func parseHgSub(hgsub []byte) []hgSubrepo {
+	var subrepos []hgSubrepo
+	inSection := false
+	for _, line := range strings.Split(string(hgsub), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "["):
+			inSection = true // Only [subpaths] section exists, and it rewrites sources rather than adding subrepositories.
+			continue
+		case inSection:
+			continue
+		}
+		// E.g., "vendor/lib = https://example.com/lib" or "vendor/lib = [git]https://example.com/lib.git".
+		eq := strings.IndexByte(line, '=')
+		if eq == -1 {
+			continue
+		}
+		sr := hgSubrepo{
+			path:   strings.TrimSpace(line[:eq]),
+			source: strings.TrimSpace(line[eq+1:]),
+			kind:   "hg",
+		}
+		if strings.HasPrefix(sr.source, "[") {
+			if end := strings.IndexByte(sr.source, ']'); end != -1 {
+				sr.kind, sr.source = sr.source[1:end], sr.source[end+1:]
+			}
+		}
+		subrepos = append(subrepos, sr)
+	}
+	return subrepos
+}
+
+// parseHgSubstate parses the contents of .hgsubstate into a map of subrepository path to revision.
+func parseHgSubstate(hgsubstate []byte) map[string]string {
+	revisions := make(map[string]string)
+	for _, line := range strings.Split(string(hgsubstate), "\n") {
+		// E.g., "7cafcd837844e784b526369c9bce262804aebc60 vendor/lib".
+		sp := strings.IndexByte(line, ' ')
+		if sp == -1 {
+			continue
+		}
+		revisions[line[sp+1:]] = line[:sp]
+	}
+	return revisions
 }
@@ -0,0 +1,673 @@
+package vcsstate
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestParseHgLog(t *testing.T) {
+	// hg log --rev "head() or bookmark()" -T json
+	in := []byte(`[
+ {
+  "bookmarks": ["feature"],
+  "branch": "default",
+  "date": [1500000000.0, -7200],
+  "desc": "Add feature.\n\nWith more details.",
+  "node": "f5ac12b15e49095c60ae0acc6da0e28d47e2a29f",
+  "parents": ["65c40fd06bc50fdd6ded3a97b213f20d31428431"],
+  "phase": "draft",
+  "rev": 1,
+  "tags": ["tip"],
+  "user": "Gopher <gopher@example.com>"
+ }
+]
+`)
+	got, err := parseHgLog(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []hgChangeset{{
+		Rev:    1,
+		Node:   "f5ac12b15e49095c60ae0acc6da0e28d47e2a29f",
+		Branch: "default",
+		Phase:  "draft",
+		User:   "Gopher <gopher@example.com>",
+		Desc:   "Add feature.\n\nWith more details.",
+		Date:   []float64{1500000000, -7200},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := got[0].time(), time.Unix(1500000000, 0); !got.Equal(want) {
+		t.Errorf("got time %v, want %v", got, want)
+	}
+}
+
+func TestHgQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "default", want: `'default'`},
+		{in: "it's", want: `'it\'s'`},
+		{in: `back\slash`, want: `'back\\slash'`},
+	}
+	for _, test := range tests {
+		if got := hgQuote(test.in); got != test.want {
+			t.Errorf("hgQuote(%q): got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseHgPatch(t *testing.T) {
+	// .hg/shelved/feature.patch
+	in := []byte(`# HG changeset patch
+# User shelve@localhost
+# Date 1500000000 0
+#      Fri Jul 14 02:40:00 2017 +0000
+# Branch feature
+# Node ID 9bfa4b3b0d9d2e3d8b1d6bd3bc20ec7b08e1ac8f
+# Parent  65c40fd06bc50fdd6ded3a97b213f20d31428431
+changes to: Add feature.
+
+diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -1,1 +1,2 @@
+ package main
++// Comment.
+diff --git a/new file.txt b/new file.txt
+new file mode 100644
+`)
+	want := StashEntry{
+		Branch:  "feature",
+		Message: "changes to: Add feature.",
+		Time:    time.Unix(1500000000, 0),
+		Base:    "65c40fd06bc50fdd6ded3a97b213f20d31428431",
+		Files:   []string{"main.go", "new file.txt"},
+	}
+	got, err := parseHgPatch(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := parseHgPatch([]byte("not a patch\n")); err == nil {
+		t.Error("got nil error for invalid patch, want non-nil")
+	}
+}
+
+func TestParseHgRebaseState(t *testing.T) {
+	in := []byte(`65c40fd06bc50fdd6ded3a97b213f20d31428431
+f5ac12b15e49095c60ae0acc6da0e28d47e2a29f
+
+False
+False
+False
+
+0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a:7cafcd837844e784b526369c9bce262804aebc60
+67253a98dcf0dd3273ea58929d7aaaa781ef6d13:-1
+f93697607c2406ba00b57fe418f4101b4e447eb8:-1
+`)
+	want := Operation{Kind: OperationRebase, Target: "f5ac12b15e49095c60ae0acc6da0e28d47e2a29f", Step: 2, Total: 3}
+	if got := parseHgRebaseState(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseHgSub(t *testing.T) {
+	hgsub := []byte(`# Subrepositories.
+vendor/lib = https://example.com/hg/lib
+vendor/gitlib = [git]https://example.com/gitlib.git
+svnlib = [svn]https://example.com/svn/trunk
+
+[subpaths]
+https://example.com/(.*) = https://mirror.example.com/\1
+`)
+	want := []hgSubrepo{
+		{path: "vendor/lib", source: "https://example.com/hg/lib", kind: "hg"},
+		{path: "vendor/gitlib", source: "https://example.com/gitlib.git", kind: "git"},
+		{path: "svnlib", source: "https://example.com/svn/trunk", kind: "svn"},
+	}
+	if got := parseHgSub(hgsub); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	hgsubstate := []byte(`65c40fd06bc50fdd6ded3a97b213f20d31428431 vendor/lib
+7cafcd837844e784b526369c9bce262804aebc60 vendor/gitlib
+42 svnlib
+`)
+	wantRevisions := map[string]string{
+		"vendor/lib":    "65c40fd06bc50fdd6ded3a97b213f20d31428431",
+		"vendor/gitlib": "7cafcd837844e784b526369c9bce262804aebc60",
+		"svnlib":        "42",
+	}
+	if got := parseHgSubstate(hgsubstate); !reflect.DeepEqual(got, wantRevisions) {
+		t.Errorf("got %v, want %v", got, wantRevisions)
+	}
+}
+
+func TestParseHgStatus(t *testing.T) {
+	// hg status -T json
+	in := []byte(`[
+ {
+  "itemtype": "file",
+  "path": "main.go",
+  "status": "M"
+ },
+ {
+  "itemtype": "file",
+  "path": "dir/new file.txt",
+  "status": "?"
+ }
+]
+`)
+	want := []hgStatusEntry{
+		{Path: "main.go", Status: "M"},
+		{Path: "dir/new file.txt", Status: "?"},
+	}
+	got, err := parseHgStatus(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Empty status.
+	got, err = parseHgStatus([]byte("[\n]\n"))
+	if err != nil || len(got) != 0 {
+		t.Errorf("got %+v, %v, want empty status", got, err)
+	}
+}
+
+func TestParseHgIdentify(t *testing.T) {
+	tests := []struct {
+		in           []byte
+		wantBranch   string
+		wantRevision string
+	}{
+		{
+			// hg identify -T json
+			in: []byte(`[
+ {
+  "bookmarks": [],
+  "branch": "feature",
+  "dirty": "+",
+  "id": "f5ac12b15e49+",
+  "node": "ffffffffffffffffffffffffffffffffffffffff",
+  "parents": ["f5ac12b15e49095c60ae0acc6da0e28d47e2a29f"],
+  "tags": ["tip"]
+ }
+]
+`),
+			wantBranch:   "feature",
+			wantRevision: "ffffffffffffffffffffffffffffffffffffffff",
+		},
+		{
+			// hg identify --rev default -T json https://example.com/repo
+			in: []byte(`[
+ {
+  "id": "65c40fd06bc50fdd6ded3a97b213f20d31428431"
+ }
+]
+`),
+			wantRevision: "65c40fd06bc50fdd6ded3a97b213f20d31428431",
+		},
+	}
+	for _, test := range tests {
+		id, err := parseHgIdentify(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := id.Branch, test.wantBranch; got != want {
+			t.Errorf("got branch %q, want %q", got, want)
+		}
+		if got, want := id.revision(), test.wantRevision; got != want {
+			t.Errorf("got revision %q, want %q", got, want)
+		}
+	}
+
+	if _, err := parseHgIdentify([]byte("[]")); err == nil {
+		t.Error("got nil error for empty output, want non-nil")
+	}
+}
+
+func TestParseHgPaths(t *testing.T) {
+	// hg paths -T json
+	in := []byte(`[
+ {
+  "name": "default",
+  "url": "https://example.com/repo"
+ },
+ {
+  "name": "fork",
+  "url": "ssh://hg@example.com/fork"
+ }
+]
+`)
+	want := map[string]string{
+		"default": "https://example.com/repo",
+		"fork":    "ssh://hg@example.com/fork",
+	}
+	got, err := parseHgPaths(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestHgHeads(t *testing.T) {
+	if _, ok := VCS(hg{}).(HeadsReporter); !ok {
+		t.Error("hg VCS doesn't implement HeadsReporter")
+	}
+	if _, ok := VCS(git{}).(HeadsReporter); ok {
+		t.Error("git VCS implements HeadsReporter")
+	}
+
+	heads := []hgChangeset{
+		{Rev: 2, Node: "2222222222222222222222222222222222222222", Branch: "default"},
+		{Rev: 3, Node: "3333333333333333333333333333333333333333", Branch: "old"},
+		{Rev: 5, Node: "5555555555555555555555555555555555555555", Branch: "default"},
+		{Rev: 6, Node: "6666666666666666666666666666666666666666", Branch: "feature"},
+	}
+	closed := []hgChangeset{heads[1]}
+	tests := []struct {
+		parent hgChangeset
+		want   Heads
+	}{
+		{
+			parent: heads[0],
+			want: Heads{
+				Parent:     "2222222222222222222222222222222222222222",
+				AtTip:      false,
+				NeedsMerge: true,
+			},
+		},
+		{
+			parent: heads[3],
+			want: Heads{
+				Parent:     "6666666666666666666666666666666666666666",
+				AtTip:      true,
+				NeedsMerge: false,
+			},
+		},
+	}
+	wantBranches := []BranchHeads{
+		{Branch: "default", Heads: []string{"5555555555555555555555555555555555555555", "2222222222222222222222222222222222222222"}},
+		{Branch: "feature", Heads: []string{"6666666666666666666666666666666666666666"}},
+		{Branch: "old", ClosedHeads: []string{"3333333333333333333333333333333333333333"}},
+	}
+	for _, test := range tests {
+		test.want.Branches = wantBranches
+		got := hgHeads(append([]hgChangeset(nil), heads...), closed, test.parent)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got %+v, want %+v", got, test.want)
+		}
+	}
+	if !wantBranches[2].Closed() || wantBranches[0].Closed() {
+		t.Error("Closed reported incorrectly")
+	}
+}
+
+func TestParseHgEvolution(t *testing.T) {
+	if _, ok := VCS(hg{}).(EvolutionReporter); !ok {
+		t.Error("hg VCS doesn't implement EvolutionReporter")
+	}
+
+	out := "draft\t\t\n" +
+		"draft\tobsolete\t\n" +
+		"draft\t\torphan\n" +
+		"secret\t\tphase-divergent content-divergent\n"
+	got := parseHgEvolution([]byte(out))
+	want := Evolution{
+		Draft:            3,
+		Secret:           1,
+		Obsolete:         1,
+		Orphan:           1,
+		PhaseDivergent:   1,
+		ContentDivergent: 1,
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if !got.Unstable() {
+		t.Error("got stable, want unstable")
+	}
+	if got := parseHgEvolution(nil); got != (Evolution{}) {
+		t.Errorf("got %+v for empty output, want zero value", got)
+	}
+}
+
+// hgRun runs hg with args in dir, and returns its output.
+func hgRun(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("hg", append([]string{"--config", "ui.username=test"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "HGPLAIN=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("hg %v: %v: %s", args, err, out)
+	}
+	return string(out)
+}
+
+func TestHgRemoteBranchAndRevision(t *testing.T) {
+	if _, err := exec.LookPath("hg"); err != nil {
+		t.Skip("hg binary not available")
+	}
+	remote, local := t.TempDir(), filepath.Join(t.TempDir(), "clone")
+	hgRun(t, remote, "init")
+	writeFile(t, filepath.Join(remote, "file"), "initial\n")
+	hgRun(t, remote, "commit", "-q", "-A", "-m", "initial")
+	hgRun(t, remote, "clone", "-q", remote, local)
+	want := hgRun(t, remote, "log", "--rev", "default", "-T", "{node}")
+
+	v, err := NewVCS(vcs.ByCmd("hg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch, revision, err := v.RemoteBranchAndRevision(local); err != nil || branch != "default" || revision != want {
+		t.Errorf("RemoteBranchAndRevision: got %q, %q, %v, want default, %q", branch, revision, err, want)
+	}
+	r, err := NewRemoteVCS(vcs.ByCmd("hg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch, revision, err := r.RemoteBranchAndRevision(remote); err != nil || branch != "default" || revision != want {
+		t.Errorf("remote RemoteBranchAndRevision: got %q, %q, %v, want default, %q", branch, revision, err, want)
+	}
+}
+
+func TestHgUnpushedCommits(t *testing.T) {
+	if _, err := exec.LookPath("hg"); err != nil {
+		t.Skip("hg binary not available")
+	}
+	dir := t.TempDir()
+	hgRun(t, dir, "init")
+	for _, subject := range []string{"first", "second", "third"} {
+		writeFile(t, filepath.Join(dir, "file"), subject+"\n")
+		hgRun(t, dir, "commit", "-q", "-A", "-m", subject)
+	}
+
+	v, err := NewVCS(vcs.ByCmd("hg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits, err := v.UnpushedCommits(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range commits {
+		got = append(got, c.Subject)
+	}
+	if want := []string{"third", "second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want the newest %q", got, want)
+	}
+}
+
+func TestHgStashEntries(t *testing.T) {
+	if _, err := exec.LookPath("hg"); err != nil {
+		t.Skip("hg binary not available")
+	}
+	dir := t.TempDir()
+	hgRun(t, dir, "init")
+	writeFile(t, filepath.Join(dir, ".hg", "hgrc"), "[extensions]\nshelve =\n")
+	writeFile(t, filepath.Join(dir, "file"), "initial\n")
+	hgRun(t, dir, "commit", "-q", "-A", "-m", "initial")
+	writeFile(t, filepath.Join(dir, "file"), "changed\n")
+	hgRun(t, dir, "shelve", "-q", "--name", "wip", "-m", "work in progress")
+
+	v, err := NewVCS(vcs.ByCmd("hg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := v.StashEntries(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "wip" || entries[0].Message != "work in progress" {
+		t.Errorf("got %+v, want the wip shelve", entries)
+	}
+}
+
+func TestHgRevsetArgs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake hg is a shell script")
+	}
+	dir := t.TempDir()
+	fakeHg, argsLog := filepath.Join(dir, "hg"), filepath.Join(dir, "args")
+	writeScript(t, fakeHg, `for arg; do echo "$arg"; done > `+argsLog+`
+echo '[]'
+`)
+	h, err := newHg(Options{Binary: fakeHg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.LocalRevision(dir, "all()")
+	if got := readLines(t, argsLog); !containsString(got, "'all()'") {
+		t.Errorf("LocalRevision: got args %q, want the branch quoted", got)
+	}
+	if ok, err := h.Contains(dir, "remote('https://example.com')", "default"); err != nil || ok {
+		t.Errorf("Contains: got %v, %v, want false", ok, err)
+	}
+	if got := readLines(t, argsLog); !containsString(got, `'remote(\'https://example.com\')'`) {
+		t.Errorf("Contains: got args %q, want the revision quoted", got)
+	}
+}
+
+func TestHgOptions(t *testing.T) {
+	home := t.TempDir()
+	h, err := newHg(newOptions([]Option{Binary(filepath.Join(home, "no-such-hg")), Home(home), NoSystemConfig(), SafeMode()}))
+	if err == nil {
+		t.Error("got no error for a missing hg binary")
+	}
+	env := h.command("", "version").Env
+	for _, want := range []string{"HOME=" + home, "HGRCPATH=" + filepath.Join(home, ".hgrc"), "HGPLAIN=1", "HGRCSKIPREPO=1"} {
+		if !containsString(env, want) {
+			t.Errorf("got environment %q, want %s", env, want)
+		}
+	}
+}
+
+func TestParseHgVersion(t *testing.T) {
+	for _, tc := range []struct {
+		in           string
+		major, minor int
+	}{
+		{"Mercurial Distributed SCM (version 6.1.1)\n", 6, 1},
+		{"Mercurial Distributed SCM (version 5.4rc0)\n", 5, 4},
+		{"Mercurial Distributed SCM (version 4.8+12-abcdef123456)\n", 4, 8},
+	} {
+		major, minor, err := parseHgVersion([]byte(tc.in))
+		if err != nil || major != tc.major || minor != tc.minor {
+			t.Errorf("parseHgVersion(%q): got %d, %d, %v, want %d, %d", tc.in, major, minor, err, tc.major, tc.minor)
+		}
+	}
+	for _, in := range []string{"", "Mercurial Distributed SCM (version unknown)\n", "Mercurial Distributed SCM (version 6)\n"} {
+		if _, _, err := parseHgVersion([]byte(in)); err == nil {
+			t.Errorf("parseHgVersion(%q): got no error", in)
+		}
+	}
+}
+
+func TestHgSafeModeOldVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake hg is a shell script")
+	}
+	fakeHg := filepath.Join(t.TempDir(), "hg")
+	writeScript(t, fakeHg, `echo "Mercurial Distributed SCM (version 5.3.2)"
+`)
+	if v, err := NewVCS(vcs.ByCmd("hg"), Binary(fakeHg), SafeMode()); err != ErrSafeModeNotSupported || v != nil {
+		t.Errorf("NewVCS: got %v, %v, want ErrSafeModeNotSupported", v, err)
+	}
+	if r, err := NewRemoteVCS(vcs.ByCmd("hg"), Binary(fakeHg), SafeMode()); err != ErrSafeModeNotSupported || r != nil {
+		t.Errorf("NewRemoteVCS: got %v, %v, want ErrSafeModeNotSupported", r, err)
+	}
+	if _, err := NewVCS(vcs.ByCmd("hg"), Binary(fakeHg)); err != nil {
+		t.Errorf("NewVCS without safe mode: got %v", err)
+	}
+}
+
+func TestHgSafeMode(t *testing.T) {
+	if _, err := exec.LookPath("hg"); err != nil {
+		t.Skip("hg binary not available")
+	}
+	dir, pwned := t.TempDir(), t.TempDir()
+	if out, err := exec.Command("hg", "init", dir).CombinedOutput(); err != nil {
+		t.Fatalf("hg init: %v: %s", err, out)
+	}
+	marker := filepath.Join(pwned, "hook")
+	writeFile(t, filepath.Join(dir, ".hg", "hgrc"), "[hooks]\npre-status = touch "+marker+"\npre-identify = touch "+marker+"\n")
+
+	v, err := NewVCS(vcs.ByCmd("hg"), SafeMode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Status(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Branch(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("hook from repository configuration ran in safe mode")
+	}
+}
+
+func TestHgAuthenticationFailed(t *testing.T) {
+	for _, tc := range []struct {
+		stderr string
+		want   bool
+	}{
+		{"abort: http authorization required for https://example.com/repo\n", true},
+		{"abort: authorization failed\n", true},
+		{"abort: response expected\n", true},
+		{"remote: git@example.com: Permission denied (publickey).\nabort: no suitable response from remote hg!\n", true},
+		{"remote: Host key verification failed.\nabort: no suitable response from remote hg!\n", true},
+		{"abort: repository default not found!\n", false},
+		{"abort: error: Name or service not known\n", false},
+	} {
+		if got := hgAuthenticationFailed([]byte(tc.stderr)); got != tc.want {
+			t.Errorf("hgAuthenticationFailed(%q): got %v, want %v", tc.stderr, got, tc.want)
+		}
+	}
+}
+
+// writeScript writes an executable shell script to path.
+func writeScript(t *testing.T, path, script string) {
+	t.Helper()
+	writeFile(t, path, "#!/bin/sh\n"+script)
+	if err := os.Chmod(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHgRemoteNonInteractive(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake hg is a shell script")
+	}
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	fakeHg := filepath.Join(dir, "hg")
+	writeScript(t, fakeHg, `for arg; do echo "$arg"; done > `+args+`
+echo "abort: http authorization required for https://example.com/repo" >&2
+exit 255
+`)
+
+	r, err := NewRemoteVCS(vcs.ByCmd("hg"), Binary(fakeHg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = r.RemoteBranchAndRevision("https://example.com/repo")
+	if _, ok := err.(AuthenticationError); !ok {
+		t.Errorf("got error %v, want AuthenticationError", err)
+	}
+	out, err := ioutil.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(string(out), "\n")
+	for _, want := range []string{"ui.interactive=false", "ui.ssh=ssh -o BatchMode=yes -o StrictHostKeyChecking=yes"} {
+		if !containsString(got, want) {
+			t.Errorf("got args %q, want %q", got, want)
+		}
+	}
+}
+
+func TestHgSSHCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake hg is a shell script")
+	}
+	const opts = " -o BatchMode=yes -o StrictHostKeyChecking=yes"
+	for _, tc := range []struct {
+		uiSSH string // Configured ui.ssh, or empty if unset.
+		safe  bool
+		want  string
+	}{
+		{want: "ssh" + opts},
+		{uiSSH: "ssh -F /etc/ci/ssh_config", want: "ssh -F /etc/ci/ssh_config" + opts},
+		{uiSSH: `"C:/Program Files/PuTTY/plink.exe" -batch`, want: `"C:/Program Files/PuTTY/plink.exe" -batch`},
+		{uiSSH: "/ci/ssh-wrapper", safe: true, want: "ssh" + opts},
+	} {
+		fakeHg := filepath.Join(t.TempDir(), "hg")
+		writeScript(t, fakeHg, `if test "$*" = "version --quiet"; then echo "Mercurial Distributed SCM (version 6.1.1)"; exit; fi
+test "$*" = "config ui.ssh" || exit 255
+test -n `+shellQuote(tc.uiSSH)+` || exit 1
+echo `+shellQuote(tc.uiSSH)+`
+`)
+		h, err := newHg(Options{Binary: fakeHg, SafeMode: tc.safe})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := h.sshCommand(""); got != tc.want {
+			t.Errorf("ui.ssh %q, safe mode %v: got %q, want %q", tc.uiSSH, tc.safe, got, tc.want)
+		}
+	}
+}
+
+func TestHgFakeSSH(t *testing.T) {
+	if _, err := exec.LookPath("hg"); err != nil {
+		t.Skip("hg binary not available")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ssh is a shell script")
+	}
+	bin := t.TempDir()
+	args := filepath.Join(bin, "args")
+	writeScript(t, filepath.Join(bin, "ssh"), `for arg; do echo "$arg"; done > `+args+`
+echo "git@example.com: Permission denied (publickey)." >&2
+exit 255
+`)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r, err := NewRemoteVCS(vcs.ByCmd("hg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = r.RemoteBranchAndRevision("ssh://example.com/repo")
+	if _, ok := err.(AuthenticationError); !ok {
+		t.Errorf("got error %v, want AuthenticationError", err)
+	}
+	out, err := ioutil.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Split(string(out), "\n"); !containsString(got, "BatchMode=yes") {
+		t.Errorf("got ssh args %q, want BatchMode=yes", got)
+	}
+}
@@ -0,0 +1,301 @@
+package vcsstate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/go/osutil"
+	"golang.org/x/tools/go/vcs"
+)
+
+// Jujutsu describes the Jujutsu version control system, which golang.org/x/tools/go/vcs
+// doesn't know about. It can be passed to NewVCS and NewRemoteVCS.
+// Jujutsu repositories use git for storage and remotes.
+var Jujutsu = &vcs.Cmd{
+	Name: "Jujutsu",
+	Cmd:  "jj",
+
+	Scheme: []string{"git", "https", "http", "git+ssh", "ssh"},
+}
+
+var _, jjBinaryError = exec.LookPath("jj")
+
+// jjCommand returns a command that runs jj with args in dir.
+// The working copy is not snapshotted, so that queries don't modify the repository;
+// Status is the exception, since it needs an up to date working-copy commit.
+func jjCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("jj", append([]string{"--no-pager", "--color", "never", "--ignore-working-copy"}, args...)...)
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+	return cmd
+}
+
+type jj struct{}
+
+func (jj) Info(dir string) (Info, error) {
+	return Info{}, nil
+}
+
+// Status returns the changes in the working-copy commit. jj records working directory
+// changes in the working-copy commit automatically, so it's snapshotted first.
+func (jj) Status(dir string) (string, error) {
+	cmd := exec.Command("jj", "--no-pager", "--color", "never", "diff", "--summary", "--revision", "@")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// Branch returns the bookmark of the closest ancestor of the working-copy commit
+// that has one. If there are several, the first in alphabetical order is returned.
+// It returns empty string if there's no such bookmark.
+func (jj) Branch(dir string) (string, error) {
+	cmd := jjCommand(dir, "log", "--no-graph", "--revisions", "heads(::@ & bookmarks())",
+		"--template", `local_bookmarks.map(|b| b.name()).join("\n") ++ "\n"`)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, name := range strings.Split(string(out), "\n") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	sort.Strings(names)
+	return names[0], nil
+}
+
+func (jj) Operations(dir string) ([]Operation, error) {
+	return nil, errors.New("not implemented for jj")
+}
+
+// LocalRevision returns the git commit id of the local defaultBranch bookmark.
+func (jj) LocalRevision(dir string, defaultBranch string) (string, error) {
+	cmd := jjCommand(dir, "log", "--no-graph", "--revisions", jjQuote(defaultBranch), "--template", `commit_id ++ "\n"`)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// Branches returns all local bookmarks. Upstream tracking is not reported.
+func (jj) Branches(dir string, defaultBranch string) ([]LocalBranch, error) {
+	cmd := jjCommand(dir, "log", "--no-graph", "--revisions", "bookmarks()", "--template", jjCommitTemplate)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	commits, err := parseJjLog(out)
+	if err != nil {
+		return nil, err
+	}
+	cmd = jjCommand(dir, "log", "--no-graph", "--revisions", "bookmarks() & ::present("+jjQuote(defaultBranch)+")", "--template", `commit_id ++ "\n"`)
+	out, err = cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]bool)
+	for _, id := range strings.Split(string(out), "\n") {
+		merged[id] = true
+	}
+	var branches []LocalBranch
+	for _, c := range commits {
+		for _, name := range c.bookmarks {
+			branches = append(branches, LocalBranch{
+				Name:     name,
+				Revision: c.Revision,
+				Time:     c.Time,
+				Bookmark: true,
+				Merged:   merged[c.Revision],
+			})
+		}
+	}
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Name < branches[j].Name })
+	return branches, nil
+}
+
+// UnpushedCommits returns commits that are not reachable from any remote bookmark.
+// An empty working-copy commit is not included.
+func (jj) UnpushedCommits(dir string, limit int) ([]Commit, error) {
+	args := []string{"log", "--no-graph", "--revisions", "all() ~ ::remote_bookmarks() ~ root() ~ (@ & empty())", "--template", jjCommitTemplate}
+	if limit > 0 {
+		args = append(args, "--limit", strconv.Itoa(limit))
+	}
+	cmd := jjCommand(dir, args...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	commits, err := parseJjLog(out)
+	if err != nil {
+		return nil, err
+	}
+	var cs []Commit
+	for _, c := range commits {
+		cs = append(cs, c.Commit)
+	}
+	return cs, nil
+}
+
+func (jj) Stash(dir string) (string, error) {
+	return "", errors.New("not implemented for jj")
+}
+
+func (jj) StashEntries(dir string) ([]StashEntry, error) {
+	return nil, errors.New("not implemented for jj")
+}
+
+func (jj) Submodules(dir string) ([]Submodule, error) {
+	return nil, errors.New("not implemented for jj")
+}
+
+// Contains reports whether the local defaultBranch bookmark contains the commit
+// specified by revision.
+func (jj) Contains(dir string, revision string, defaultBranch string) (bool, error) {
+	return jjContains(dir, revision, jjQuote(defaultBranch))
+}
+
+// RemoteContains reports whether the defaultBranch bookmark of the origin remote,
+// as last fetched, contains the commit specified by revision.
+func (jj) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
+	return jjContains(dir, revision, jjQuote(defaultBranch)+"@"+jjQuote("origin"))
+}
+
+// RemoteURL returns the URL of the origin git remote.
+func (jj) RemoteURL(dir string) (string, error) {
+	cmd := jjCommand(dir, "git", "remote", "list")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	url, ok := parseJjGitRemotes(out)["origin"]
+	if !ok {
+		return "", ErrNoRemote
+	}
+	return url, nil
+}
+
+func (j jj) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
+	remoteURL, err := j.RemoteURL(dir)
+	if err != nil {
+		return "", "", err
+	}
+	return remoteJj{}.RemoteBranchAndRevision(remoteURL)
+}
+
+func (jj) CachedRemoteDefaultBranch() (string, error) {
+	return "", fmt.Errorf("not implemented for jj, just use NoRemoteDefaultBranch")
+}
+
+func (jj) NoRemoteDefaultBranch() string {
+	return "main"
+}
+
+type remoteJj struct{}
+
+// RemoteBranchAndRevision queries the git remote at remoteURL using git,
+// since Jujutsu repositories use git remotes.
+func (remoteJj) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
+	r, err := NewRemoteVCS(vcs.ByCmd("git"))
+	if err != nil {
+		return "", "", err
+	}
+	return r.RemoteBranchAndRevision(remoteURL)
+}
+
+// jjContains reports whether the commit specified by revision is an ancestor
+// of the commit specified by the revset expression target.
+func jjContains(dir, revision, target string) (bool, error) {
+	cmd := jjCommand(dir, "log", "--no-graph", "--revisions", "present("+jjQuote(revision)+") & ::present("+target+")", "--template", `commit_id ++ "\n"`)
+
+	stdout, stderr, err := dividedOutput(cmd)
+	if err != nil {
+		return false, fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	return len(bytes.TrimSpace(stdout)) != 0, nil // Non-empty output means this commit is indeed contained.
+}
+
+// jjQuote quotes s as a string literal for use in a jj revset expression.
+func jjQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// jjCommitTemplate is a template that prints the commit id, author, commit time,
+// subject and local bookmarks of a commit, separated by NUL bytes, one commit per line.
+const jjCommitTemplate = `commit_id ++ "\0" ++ author.name() ++ " <" ++ author.email() ++ ">\0" ++ ` +
+	`committer.timestamp().utc().format("%s") ++ "\0" ++ description.first_line() ++ "\0" ++ ` +
+	`local_bookmarks.map(|b| b.name()).join(",") ++ "\n"`
+
+// jjCommit is a commit, as printed by jjCommitTemplate.
+type jjCommit struct {
+	Commit
+	bookmarks []string
+}
+
+// parseJjLog parses jj log output formatted with jjCommitTemplate.
+// Branch is set to the first bookmark of a commit.
+func parseJjLog(out []byte) ([]jjCommit, error) {
+	var commits []jjCommit
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("unexpected jj log line: %q", line)
+		}
+		sec, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		c := jjCommit{Commit: Commit{
+			Revision: fields[0],
+			Author:   fields[1],
+			Time:     time.Unix(sec, 0),
+			Subject:  fields[3],
+		}}
+		if fields[4] != "" {
+			c.bookmarks = strings.Split(fields[4], ",")
+			c.Branch = c.bookmarks[0]
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// parseJjGitRemotes parses jj git remote list output, and returns remote URLs by name.
+func parseJjGitRemotes(out []byte) map[string]string {
+	remotes := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		// Each line is "<name> <url>".
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		remotes[fields[0]] = fields[1]
+	}
+	return remotes
+}
@@ -0,0 +1,155 @@
+package vcsstate
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJjLog(t *testing.T) {
+	// jj log --no-graph --revisions "bookmarks()" --template jjCommitTemplate
+	in := []byte("7cafcd837844e784b526369c9bce262804aebc60\x00Gopher <gopher@example.com>\x001500000000\x00Add feature.\x00feature,feature-2\n" +
+		"0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a\x00Gopher <gopher@example.com>\x001499990000\x00Initial commit.\x00\n")
+	want := []jjCommit{
+		{
+			Commit: Commit{
+				Revision: "7cafcd837844e784b526369c9bce262804aebc60",
+				Author:   "Gopher <gopher@example.com>",
+				Time:     time.Unix(1500000000, 0),
+				Subject:  "Add feature.",
+				Branch:   "feature",
+			},
+			bookmarks: []string{"feature", "feature-2"},
+		},
+		{
+			Commit: Commit{
+				Revision: "0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a",
+				Author:   "Gopher <gopher@example.com>",
+				Time:     time.Unix(1499990000, 0),
+				Subject:  "Initial commit.",
+			},
+		},
+	}
+	got, err := parseJjLog(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseJjGitRemotes(t *testing.T) {
+	// jj git remote list
+	in := []byte("origin https://example.com/repo.git\nupstream git@example.com:upstream/repo.git\n")
+	want := map[string]string{
+		"origin":   "https://example.com/repo.git",
+		"upstream": "git@example.com:upstream/repo.git",
+	}
+	if got := parseJjGitRemotes(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestJjQuote(t *testing.T) {
+	if got, want := jjQuote(`a"b\c`), `"a\"b\\c"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestFromDir(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub", "dir")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := FromDir(sub); err == nil {
+		t.Error("got no error for a directory without version control")
+	}
+
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if cmd, dir, err := FromDir(sub); err != nil || cmd.Cmd != "git" || dir != root {
+		t.Errorf("git: got %v, %q, %v, want git, %q", cmd, dir, err, root)
+	}
+
+	// A colocated jj repository has both .jj and .git directories.
+	if err := os.Mkdir(filepath.Join(root, ".jj"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if cmd, dir, err := FromDir(sub); err != nil || cmd != Jujutsu || dir != root {
+		t.Errorf("jj: got %v, %q, %v, want jj, %q", cmd, dir, err, root)
+	}
+
+	// The closest repository root wins.
+	writeFile(t, filepath.Join(sub, ".fslckout"), "")
+	if cmd, dir, err := FromDir(sub); err != nil || cmd != Fossil || dir != sub {
+		t.Errorf("fossil: got %v, %q, %v, want fossil, %q", cmd, dir, err, sub)
+	}
+}
+
+func TestJj(t *testing.T) {
+	if jjBinaryError != nil {
+		t.Skip("jj binary not available")
+	}
+	dir := t.TempDir()
+	t.Setenv("JJ_CONFIG", filepath.Join(t.TempDir(), "config.toml"))
+	jjRun(t, dir, "git", "init", "--colocate")
+	writeFile(t, filepath.Join(dir, "file"), "initial\n")
+	jjRun(t, dir, "commit", "-m", "initial")
+	jjRun(t, dir, "bookmark", "create", "main", "-r", "@-")
+	main := strings.TrimSpace(jjRun(t, dir, "log", "--no-graph", "-r", "main", "-T", "commit_id"))
+
+	if cmd, _, err := FromDir(dir); err != nil || cmd != Jujutsu {
+		t.Errorf("FromDir: got %v, %v, want jj", cmd, err)
+	}
+	var j VCS = jj{}
+	if got, err := j.Branch(dir); err != nil || got != "main" {
+		t.Errorf("Branch: got %q, %v, want main", got, err)
+	}
+	if got, err := j.LocalRevision(dir, "main"); err != nil || got != main {
+		t.Errorf("LocalRevision: got %q, %v, want %q", got, err, main)
+	}
+	if got, err := j.Status(dir); err != nil || got != "" {
+		t.Errorf("Status: got %q, %v, want clean", got, err)
+	}
+	writeFile(t, filepath.Join(dir, "file"), "changed\n")
+	if got, err := j.Status(dir); err != nil || got != "M file\n" {
+		t.Errorf("Status: got %q, %v, want modified file", got, err)
+	}
+	if got, err := j.Contains(dir, main, "main"); err != nil || !got {
+		t.Errorf("Contains: got %v, %v, want true", got, err)
+	}
+	if got, err := j.RemoteContains(dir, main, "main"); err != nil || got {
+		t.Errorf("RemoteContains: got %v, %v, want false", got, err)
+	}
+	if got, err := j.RemoteURL(dir); err != ErrNoRemote {
+		t.Errorf("RemoteURL: got %q, %v, want ErrNoRemote", got, err)
+	}
+	commits, err := j.UnpushedCommits(dir, 0)
+	if err != nil || len(commits) != 2 || commits[1].Revision != main || commits[1].Branch != "main" {
+		t.Errorf("UnpushedCommits: got %+v, %v, want the working-copy and main commits", commits, err)
+	}
+	branches, err := j.Branches(dir, "main")
+	if err != nil || len(branches) != 1 || branches[0].Name != "main" || !branches[0].Merged {
+		t.Errorf("Branches: got %+v, %v, want merged main", branches, err)
+	}
+}
+
+// jjRun runs jj with args in dir, and returns its output.
+func jjRun(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("jj", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "JJ_USER=Gopher", "JJ_EMAIL=gopher@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("jj %v: %v: %s", args, err, out)
+	}
+	return string(out)
+}
@@ -0,0 +1,137 @@
+package vcsstate
+
+import "path/filepath"
+
+// Options configures how a VCS or RemoteVCS runs its version control system binary.
+// Options are currently only supported by git and hg, and are ignored by other backends,
+// except for SafeMode, which they reject with ErrSafeModeNotSupported.
+type Options struct {
+	// Binary is the name or path of the version control system binary.
+	// If empty, the default name is looked up in PATH.
+	Binary string
+
+	// Env is additional environment variables, in the form "key=value",
+	// set for the binary on top of the process environment.
+	Env []string
+
+	// Home, if non-empty, is used as the binary's home directory,
+	// isolating it from the user's global configuration.
+	Home string
+
+	// NoSystemConfig disables reading system-wide configuration.
+	// For git, GIT_CONFIG_NOSYSTEM is set. For hg, HGRCPATH is set
+	// to read only the user configuration.
+	NoSystemConfig bool
+
+	// AllowEnv is the names of environment variables to keep from the process
+	// environment, even though they'd otherwise be removed because they can
+	// redirect commands to another repository or configuration, such as GIT_DIR,
+	// GIT_CONFIG_GLOBAL, GIT_CONFIG_KEY_0 or HGRCPATH.
+	AllowEnv []string
+
+	// SafeMode disables configuration of the repository being queried that
+	// makes the binary run commands, so that untrusted repositories can be
+	// queried safely. For git, this includes core.fsmonitor, hooks, external
+	// diff, textconv and filter drivers, and submodule working trees aren't
+	// inspected by Status. For hg, the repository's .hg/hgrc isn't read,
+	// which disables its hooks and extensions; this requires hg 5.4 or newer.
+	// ErrSafeModeNotSupported is returned for other backends and older hg versions.
+	SafeMode bool
+
+	// AllowTransports is the names of git transports that are rejected by default,
+	// because they can run arbitrary commands, to allow anyway. For example, "ext"
+	// allows remote URLs like "ext::ssh -i key host %S repo".
+	AllowTransports []string
+
+	// Credentials provides credentials for remote repositories accessed over HTTP.
+	// They're passed to the binary through its environment or a temporary file,
+	// never through command-line arguments. If nil, the binary's own configuration,
+	// such as git credential helpers, is used, but it's never allowed to prompt.
+	Credentials CredentialProvider
+
+	// SSH configures the ssh command used for remote repositories accessed over ssh.
+	SSH SSHOptions
+
+	// RecurseSubmodules makes Submodules also report the nested submodules and
+	// subrepositories of initialized ones, with paths relative to the repository
+	// that Submodules is called on.
+	RecurseSubmodules bool
+}
+
+// Option sets an option in Options.
+type Option func(*Options)
+
+// Binary sets the name or path of the version control system binary.
+func Binary(path string) Option {
+	return func(o *Options) { o.Binary = path }
+}
+
+// Env adds environment variables, in the form "key=value", to set for the binary.
+func Env(env ...string) Option {
+	return func(o *Options) { o.Env = append(o.Env, env...) }
+}
+
+// Home sets the home directory of the binary.
+func Home(dir string) Option {
+	return func(o *Options) { o.Home = dir }
+}
+
+// NoSystemConfig disables reading system-wide configuration.
+func NoSystemConfig() Option {
+	return func(o *Options) { o.NoSystemConfig = true }
+}
+
+// AllowEnv keeps the named environment variables from the process environment,
+// even though they'd otherwise be removed. See Options.AllowEnv.
+func AllowEnv(names ...string) Option {
+	return func(o *Options) { o.AllowEnv = append(o.AllowEnv, names...) }
+}
+
+// SafeMode enables safe mode. See Options.SafeMode.
+func SafeMode() Option {
+	return func(o *Options) { o.SafeMode = true }
+}
+
+// AllowTransport allows the named git transports, which are rejected by default.
+// See Options.AllowTransports.
+func AllowTransport(names ...string) Option {
+	return func(o *Options) { o.AllowTransports = append(o.AllowTransports, names...) }
+}
+
+// Credentials sets the provider of credentials for remote repositories.
+// See Options.Credentials.
+func Credentials(provider CredentialProvider) Option {
+	return func(o *Options) { o.Credentials = provider }
+}
+
+// SSH sets the options for the ssh command. See SSHOptions.
+func SSH(opts SSHOptions) Option {
+	return func(o *Options) { o.SSH = opts }
+}
+
+// RecurseSubmodules makes Submodules report nested submodules.
+// See Options.RecurseSubmodules.
+func RecurseSubmodules() Option {
+	return func(o *Options) { o.RecurseSubmodules = true }
+}
+
+// newOptions returns Options with opts applied.
+func newOptions(opts []Option) Options {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// env returns the environment that o configures. The variables in set,
+// in the form "key=value", are set before the ones configured by o.
+func (o Options) env(set ...string) environment {
+	if o.Home != "" {
+		set = append(set, "HOME="+o.Home, "XDG_CONFIG_HOME="+filepath.Join(o.Home, ".config"))
+	}
+	return environment{
+		set:   append(set, o.Env...),
+		allow: o.AllowEnv,
+	}
+}
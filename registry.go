@@ -0,0 +1,189 @@
+package vcsstate
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// Factory creates VCS and RemoteVCS implementations for a version control system,
+// and detects its repositories. Implementations are registered with Register.
+type Factory interface {
+	// NewVCS creates a VCS configured with opts. It may return a non-nil VCS
+	// along with an error, such as when the version control system binary is not available.
+	NewVCS(opts Options) (VCS, error)
+
+	// NewRemoteVCS creates a RemoteVCS configured with opts.
+	NewRemoteVCS(opts Options) (RemoteVCS, error)
+
+	// Detect reports whether dir is the root directory of a repository.
+	Detect(dir string) bool
+}
+
+var registry struct {
+	mu       sync.RWMutex
+	backends []backend // In order of registration.
+}
+
+type backend struct {
+	cmd     *vcs.Cmd
+	factory Factory
+}
+
+// Register makes a version control system available to NewVCS, NewRemoteVCS
+// and FromDir. The name is the name of its binary, as in vcs.Cmd.Cmd.
+//
+// If a factory is already registered with the same name, it's replaced.
+// Later registrations take precedence in FromDir, so a version control system
+// that shares metadata with another, like Jujutsu does with git, should be
+// registered after it. The built-in backends are registered first.
+func Register(name string, factory Factory) {
+	if factory == nil {
+		panic("vcsstate: Register factory is nil")
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for i, b := range registry.backends {
+		if b.cmd.Cmd == name {
+			registry.backends = append(registry.backends[:i], registry.backends[i+1:]...)
+			break
+		}
+	}
+	registry.backends = append(registry.backends, backend{cmd: cmdByName(name), factory: factory})
+}
+
+// lookupFactory returns the factory registered with name.
+func lookupFactory(name string) (Factory, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	for _, b := range registry.backends {
+		if b.cmd.Cmd == name {
+			return b.factory, true
+		}
+	}
+	return nil, false
+}
+
+// detect returns the version control system whose factory detects dir
+// as a repository root, preferring later registrations, or nil if there's none.
+func detect(dir string) *vcs.Cmd {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	for i := len(registry.backends) - 1; i >= 0; i-- {
+		if b := registry.backends[i]; b.factory.Detect(dir) {
+			return b.cmd
+		}
+	}
+	return nil
+}
+
+// cmdByName returns the vcs.Cmd for the version control system with
+// the given binary name. Version control systems that golang.org/x/tools/go/vcs
+// doesn't know about get a vcs.Cmd with just the name set.
+func cmdByName(name string) *vcs.Cmd {
+	switch name {
+	case Fossil.Cmd:
+		return Fossil
+	case Jujutsu.Cmd:
+		return Jujutsu
+	}
+	if cmd := vcs.ByCmd(name); cmd != nil {
+		return cmd
+	}
+	return &vcs.Cmd{Name: name, Cmd: name}
+}
+
+// builtinFactory is a Factory for a built-in version control system,
+// detected by the presence of one of its metadata files or directories.
+type builtinFactory struct {
+	newVCS       func(Options) (VCS, error)
+	newRemoteVCS func(Options) (RemoteVCS, error)
+	metadata     []string
+	safeMode     bool // Whether Options.SafeMode is supported.
+}
+
+func (f builtinFactory) NewVCS(opts Options) (VCS, error) {
+	if opts.SafeMode && !f.safeMode {
+		return nil, ErrSafeModeNotSupported
+	}
+	return f.newVCS(opts)
+}
+
+func (f builtinFactory) NewRemoteVCS(opts Options) (RemoteVCS, error) {
+	if opts.SafeMode && !f.safeMode {
+		return nil, ErrSafeModeNotSupported
+	}
+	return f.newRemoteVCS(opts)
+}
+
+func (f builtinFactory) Detect(dir string) bool {
+	for _, name := range f.metadata {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	// Registered in reverse order of preference, since later registrations take precedence.
+	Register("bzr", builtinFactory{
+		newVCS:       func(Options) (VCS, error) { return bzr{}, bzrBinaryError },
+		newRemoteVCS: func(Options) (RemoteVCS, error) { return remoteBzr{}, bzrBinaryError },
+		metadata:     []string{".bzr"},
+	})
+	Register("svn", builtinFactory{
+		newVCS:       func(Options) (VCS, error) { return svn{}, svnBinaryError },
+		newRemoteVCS: func(Options) (RemoteVCS, error) { return remoteSvn{}, svnBinaryError },
+		metadata:     []string{".svn"},
+	})
+	Register("hg", builtinFactory{
+		newVCS: func(opts Options) (VCS, error) {
+			h, err := newHg(opts)
+			if err != nil && opts.SafeMode {
+				// Don't return a backend that may run the repository's hooks.
+				return nil, err
+			}
+			return h, err
+		},
+		newRemoteVCS: func(opts Options) (RemoteVCS, error) {
+			h, err := newHg(opts)
+			if err != nil && opts.SafeMode {
+				return nil, err
+			}
+			return remoteHg{hg: h}, err
+		},
+		metadata: []string{".hg"},
+		safeMode: true,
+	})
+	Register("git", builtinFactory{
+		newVCS: func(opts Options) (VCS, error) {
+			g, err := newGit(opts)
+			if err != nil {
+				return nil, err
+			}
+			return g, nil
+		},
+		newRemoteVCS: func(opts Options) (RemoteVCS, error) {
+			g, err := newGit(opts)
+			if err != nil {
+				return nil, err
+			}
+			return remoteGit{git: g}, nil
+		},
+		metadata: []string{".git"},
+		safeMode: true,
+	})
+	Register("fossil", builtinFactory{
+		newVCS:       func(Options) (VCS, error) { return fossil{}, fossilBinaryError },
+		newRemoteVCS: func(Options) (RemoteVCS, error) { return remoteFossil{}, fossilBinaryError },
+		metadata:     []string{".fslckout", "_FOSSIL_"},
+	})
+	Register("jj", builtinFactory{
+		newVCS:       func(Options) (VCS, error) { return jj{}, jjBinaryError },
+		newRemoteVCS: func(Options) (RemoteVCS, error) { return remoteJj{}, jjBinaryError },
+		metadata:     []string{".jj"},
+	})
+}
@@ -0,0 +1,66 @@
+package vcsstate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// fakeVCS is a VCS registered by TestRegister.
+type fakeVCS struct{ VCS }
+
+type fakeFactory struct{}
+
+func (fakeFactory) NewVCS(Options) (VCS, error)             { return fakeVCS{}, nil }
+func (fakeFactory) NewRemoteVCS(Options) (RemoteVCS, error) { return remoteHg{}, nil }
+func (fakeFactory) Detect(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".fake"))
+	return err == nil
+}
+
+func TestRegister(t *testing.T) {
+	saved := append([]backend(nil), registry.backends...)
+	defer func() { registry.backends = saved }()
+
+	fake := &vcs.Cmd{Name: "Fake", Cmd: "fake"}
+	if _, err := NewVCS(fake); err == nil {
+		t.Fatal("got no error before registration")
+	}
+	Register("fake", fakeFactory{})
+	if v, err := NewVCS(fake); err != nil || v != (fakeVCS{}) {
+		t.Errorf("NewVCS: got %v, %v, want fakeVCS", v, err)
+	}
+	if _, err := NewRemoteVCS(fake); err != nil {
+		t.Errorf("NewRemoteVCS: got %v", err)
+	}
+
+	// A later registration takes precedence over git in detection.
+	root := t.TempDir()
+	for _, name := range []string{".git", ".fake"} {
+		if err := os.Mkdir(filepath.Join(root, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cmd, dir, err := FromDir(root); err != nil || cmd.Cmd != "fake" || cmd.Name != "fake" || dir != root {
+		t.Errorf("FromDir: got %v, %q, %v, want fake, %q", cmd, dir, err, root)
+	}
+
+	// Registering with the same name replaces the earlier factory.
+	Register("hg", fakeFactory{})
+	if v, err := NewVCS(vcs.ByCmd("hg")); err != nil || v != (fakeVCS{}) {
+		t.Errorf("NewVCS after replacing hg: got %v, %v, want fakeVCS", v, err)
+	}
+}
+
+func TestSafeModeNotSupported(t *testing.T) {
+	for _, cmd := range []*vcs.Cmd{vcs.ByCmd("bzr"), vcs.ByCmd("svn"), Fossil, Jujutsu} {
+		if v, err := NewVCS(cmd, SafeMode()); err != ErrSafeModeNotSupported || v != nil {
+			t.Errorf("NewVCS(%s): got %v, %v, want ErrSafeModeNotSupported", cmd.Cmd, v, err)
+		}
+		if r, err := NewRemoteVCS(cmd, SafeMode()); err != ErrSafeModeNotSupported || r != nil {
+			t.Errorf("NewRemoteVCS(%s): got %v, %v, want ErrSafeModeNotSupported", cmd.Cmd, r, err)
+		}
+	}
+}
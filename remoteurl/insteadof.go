@@ -0,0 +1,56 @@
+package remoteurl
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Rewrite is a git url.<Base>.insteadOf rule, which rewrites URLs that start
+// with InsteadOf to start with Base instead.
+type Rewrite struct {
+	Base      string
+	InsteadOf string
+}
+
+// Rewrites is a set of insteadOf rules.
+type Rewrites []Rewrite
+
+// Apply rewrites rawURL like git does, using the rule with the longest
+// InsteadOf that's a prefix of rawURL. If none match, rawURL is returned as is.
+func (rs Rewrites) Apply(rawURL string) string {
+	var best *Rewrite
+	for i, r := range rs {
+		if strings.HasPrefix(rawURL, r.InsteadOf) && (best == nil || len(r.InsteadOf) > len(best.InsteadOf)) {
+			best = &rs[i]
+		}
+	}
+	if best == nil {
+		return rawURL
+	}
+	return best.Base + rawURL[len(best.InsteadOf):]
+}
+
+// Parse applies rs to rawURL, then parses the result.
+func (rs Rewrites) Parse(rawURL string) (*URL, error) {
+	return Parse(rs.Apply(rawURL))
+}
+
+// ParseGitRewrites parses the output of git config -z --get-regexp
+// for url.<base>.insteadof keys. The rules of a repository can be obtained
+// with vcsstate, whose git backend implements vcsstate.URLRewriter.
+func ParseGitRewrites(out []byte) Rewrites {
+	var rs Rewrites
+	for _, entry := range bytes.Split(out, []byte{0}) {
+		i := bytes.IndexByte(entry, '\n')
+		if i == -1 {
+			continue
+		}
+		key, value := string(entry[:i]), string(entry[i+1:])
+		// The subsection, which is the base, keeps its case, unlike the rest of the key.
+		if len(key) < len("url..insteadof") || !strings.HasPrefix(key, "url.") || !strings.HasSuffix(key, ".insteadof") {
+			continue
+		}
+		rs = append(rs, Rewrite{Base: key[len("url.") : len(key)-len(".insteadof")], InsteadOf: value})
+	}
+	return rs
+}
@@ -0,0 +1,218 @@
+// Package remoteurl parses, normalizes and compares remote repository URLs,
+// such as the ones returned by vcsstate's RemoteURL.
+//
+// It understands URLs with a scheme, like "https://host/path" and "ssh://user@host/path",
+// the scp-like "user@host:path" syntax of git, and local paths.
+package remoteurl
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// URL is a parsed remote repository URL.
+type URL struct {
+	Scheme   string        // Lower case, such as "https" or "ssh". Local paths have "file".
+	User     *url.Userinfo // Username and password, if any.
+	Host     string        // Host name, and port if any. Empty for local repositories.
+	Path     string        // Path on the host, or local path.
+	Fragment string        // Revision or branch in hg URLs, like "https://host/repo#branch".
+
+	// SCP reports whether the URL is written in the scp-like syntax "user@host:path",
+	// where the path is relative to the user's home directory unless it starts with '/'.
+	SCP bool
+}
+
+// schemeAliases maps schemes that are equivalent to another one to it.
+var schemeAliases = map[string]string{
+	"git+ssh": "ssh",
+	"ssh+git": "ssh",
+}
+
+// Parse parses a remote repository URL. Local paths, which have neither a scheme
+// nor the scp-like syntax, are parsed as file URLs with the path as is.
+// Remote helper addresses, like "transport::address", are not supported.
+func Parse(rawURL string) (*URL, error) {
+	switch {
+	case rawURL == "":
+		return nil, errors.New("empty remote URL")
+	case strings.ContainsAny(rawURL, "\x00\r\n"):
+		return nil, fmt.Errorf("invalid remote URL %q: must not contain NUL or newline characters", redactUserinfo(rawURL))
+	}
+	if i := strings.Index(rawURL, "://"); i != -1 && !strings.ContainsAny(rawURL[:i], "/:") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			// Don't include rawURL, which may contain a password, in the error.
+			if e, ok := err.(*url.Error); ok {
+				err = e.Err
+			}
+			return nil, fmt.Errorf("invalid remote URL: %v", err)
+		}
+		scheme := strings.ToLower(u.Scheme)
+		if alias, ok := schemeAliases[scheme]; ok {
+			scheme = alias
+		}
+		return &URL{Scheme: scheme, User: u.User, Host: strings.ToLower(u.Host), Path: u.Path, Fragment: u.Fragment}, nil
+	}
+	if i := strings.Index(rawURL, "::"); i != -1 && !strings.ContainsAny(rawURL[:i], "/@[") {
+		return nil, fmt.Errorf("unsupported remote URL %q: remote helper addresses aren't supported", redactUserinfo(rawURL))
+	}
+	if host, path, ok := splitSCP(rawURL); ok {
+		u := &URL{Scheme: "ssh", Host: host, Path: path, SCP: true}
+		if i := strings.LastIndexByte(host, '@'); i != -1 {
+			u.User, u.Host = url.User(host[:i]), host[i+1:]
+		}
+		u.Host = strings.ToLower(u.Host)
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid remote URL %q: empty host", redactUserinfo(rawURL))
+		}
+		return u, nil
+	}
+	return &URL{Scheme: "file", Path: filepath.ToSlash(rawURL)}, nil
+}
+
+// splitSCP splits rawURL into its host, including the user if any, and path
+// if it's in the scp-like syntax. Like git, it requires the first colon to come
+// before any slash, and doesn't treat a Windows drive letter, as in "C:\repo", as a host.
+func splitSCP(rawURL string) (host, path string, ok bool) {
+	i := strings.IndexByte(rawURL, ':')
+	if strings.HasPrefix(rawURL, "[") || strings.Contains(rawURL, "@[") {
+		// A bracketed host, like "[::1]:path", contains colons.
+		j := strings.IndexByte(rawURL, ']')
+		if j == -1 || !strings.HasPrefix(rawURL[j+1:], ":") {
+			return "", "", false
+		}
+		i = j + 1
+	}
+	switch {
+	case i <= 0,
+		strings.ContainsAny(rawURL[:i], `/\`),
+		i == 1 && isLetter(rawURL[0]):
+		return "", "", false
+	}
+	return rawURL[:i], rawURL[i+1:], true
+}
+
+func isLetter(c byte) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' }
+
+// String reassembles the URL in the syntax it was parsed from.
+// Local paths are returned as is.
+func (u *URL) String() string {
+	switch {
+	case u.SCP:
+		host := u.Host
+		if u.User != nil {
+			host = u.User.Username() + "@" + host
+		}
+		return host + ":" + u.Path
+	case u.Scheme == "file" && u.Host == "" && !strings.HasPrefix(u.Path, "/"):
+		return u.Path
+	}
+	return (&url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host, Path: u.Path, Fragment: u.Fragment}).String()
+}
+
+// Redacted is like String, but it replaces any password with "xxxxx".
+// Since access tokens are often given as the username in http and https URLs,
+// a username without a password is replaced too for those schemes.
+func (u *URL) Redacted() string {
+	if u.User == nil {
+		return u.String()
+	}
+	r := *u
+	if _, ok := u.User.Password(); ok {
+		r.User = url.UserPassword(u.User.Username(), "xxxxx")
+	} else if u.Scheme == "http" || u.Scheme == "https" {
+		r.User = url.User("xxxxx")
+	}
+	return r.String()
+}
+
+// defaultPorts are the default ports of schemes, which are omitted from canonical URLs.
+var defaultPorts = map[string]string{
+	"ssh":   "22",
+	"git":   "9418",
+	"http":  "80",
+	"https": "443",
+}
+
+// Canonical returns a normalized form of the URL that identifies the repository
+// independently of how it's accessed, for comparing URLs.
+//
+// For a remote repository, it's the lower case host, with the port only if it's not
+// the default one of the scheme, followed by the cleaned path without leading slashes
+// or a ".git" suffix, like "github.com/foo/bar". The scheme, user, and fragment
+// are dropped. Paths relative to the user's home directory, as in scp-like URLs
+// and hg's ssh URLs, aren't distinguished from absolute ones, which matches
+// how hosting services treat them.
+//
+// For a local repository, it's "file://" followed by the cleaned path,
+// without a trailing "/.git" directory.
+func (u *URL) Canonical() string {
+	if u.Scheme == "file" && u.Host == "" {
+		p := path.Clean(u.Path)
+		if base := path.Base(p); base == ".git" && p != base {
+			p = path.Dir(p)
+		}
+		return "file://" + p
+	}
+	host := u.Host
+	if i := strings.LastIndexByte(host, ':'); i != -1 && !strings.HasSuffix(host, "]") {
+		if port := host[i+1:]; port == "" || port == defaultPorts[u.Scheme] {
+			host = host[:i]
+		}
+	}
+	p := strings.TrimLeft(path.Clean("/"+u.Path), "/")
+	p = strings.TrimSuffix(p, ".git")
+	if p == "" {
+		return host
+	}
+	return host + "/" + p
+}
+
+// Redact returns rawURL with any password, or username that may be an access token,
+// replaced as in URL.Redacted. If rawURL can't be parsed, everything before the last '@'
+// of its host part is replaced.
+func Redact(rawURL string) string {
+	u, err := Parse(rawURL)
+	if err == nil {
+		return u.Redacted()
+	}
+	return redactUserinfo(rawURL)
+}
+
+// redactUserinfo returns rawURL with everything before the last '@' of its host part,
+// which is where a password or access token would be, replaced. It doesn't parse rawURL,
+// so it can be used on URLs that are invalid.
+func redactUserinfo(rawURL string) string {
+	rest, prefix := rawURL, ""
+	if i := strings.Index(rawURL, "://"); i != -1 {
+		prefix, rest = rawURL[:i+len("://")], rawURL[i+len("://"):]
+	}
+	host := rest
+	if i := strings.IndexByte(host, '/'); i != -1 {
+		host = host[:i]
+	}
+	if i := strings.LastIndexByte(host, '@'); i != -1 {
+		return prefix + "xxxxx" + rest[i:]
+	}
+	return rawURL
+}
+
+// Same reports whether remote URLs a and b refer to the same repository,
+// by comparing their canonical forms. URLs that can't be parsed are
+// the same only if they're equal.
+func Same(a, b string) bool {
+	ua, err := Parse(a)
+	if err != nil {
+		return a == b
+	}
+	ub, err := Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Canonical() == ub.Canonical()
+}
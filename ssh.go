@@ -0,0 +1,98 @@
+package vcsstate
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SSHOptions configures the ssh command used for remote repositories accessed over ssh.
+// The options are passed to ssh on its command line, so they're only used with OpenSSH.
+// They aren't added to a configured ssh command that's PuTTY's plink or TortoisePlink,
+// or for git, to a GIT_SSH program that isn't named ssh, unless GIT_SSH_VARIANT is ssh.
+type SSHOptions struct {
+	// Binary is the name or path of the ssh binary. If empty, the configured ssh
+	// command, such as git's core.sshCommand or hg's ui.ssh, is used, or else "ssh".
+	Binary string
+
+	// KnownHostsFile, if non-empty, is the known_hosts file used to verify
+	// host keys, instead of the user's.
+	KnownHostsFile string
+
+	// IdentityFile, if non-empty, is the private key file used to authenticate.
+	// Other identities, such as ones from ssh-agent, aren't used.
+	IdentityFile string
+
+	// ConnectTimeout, if positive, is the timeout for connecting to the server.
+	// It's rounded up to whole seconds.
+	ConnectTimeout time.Duration
+
+	// NoBatchMode disables ssh's BatchMode, which is enabled by default so that
+	// ssh fails rather than prompt for a password or passphrase. Disabling it is
+	// only useful with a non-interactive SSH_ASKPASS program.
+	NoBatchMode bool
+}
+
+// command returns the ssh command line, to be interpreted by a shell, that runs base,
+// an ssh command line, with o applied. Unknown host keys are always rejected.
+func (o SSHOptions) command(base string) string {
+	args := []string{base}
+	if !o.NoBatchMode {
+		args = append(args, "-o", "BatchMode=yes")
+	}
+	// Default for StrictHostKeyChecking is "ask", which we don't want since this is non-interactive
+	// and we prefer to fail than block asking for user input.
+	args = append(args, "-o", "StrictHostKeyChecking=yes")
+	if o.KnownHostsFile != "" {
+		args = append(args, "-o", shellQuote("UserKnownHostsFile="+sshQuote(o.KnownHostsFile)))
+	}
+	if o.IdentityFile != "" {
+		args = append(args, "-i", shellQuote(o.IdentityFile), "-o", "IdentitiesOnly=yes")
+	}
+	if o.ConnectTimeout > 0 {
+		seconds := (o.ConnectTimeout + time.Second - 1) / time.Second
+		args = append(args, "-o", "ConnectTimeout="+strconv.Itoa(int(seconds)))
+	}
+	return strings.Join(args, " ")
+}
+
+// sshProgram returns the program, the first word, of c, an ssh command line
+// to be interpreted by a shell. Only single and double quotes are handled.
+func sshProgram(c string) string {
+	c = strings.TrimLeft(c, " \t")
+	if c != "" && (c[0] == '\'' || c[0] == '"') {
+		if i := strings.IndexByte(c[1:], c[0]); i != -1 {
+			return c[1 : 1+i]
+		}
+	}
+	if i := strings.IndexAny(c, " \t"); i != -1 {
+		return c[:i]
+	}
+	return c
+}
+
+// sshProgramName returns the lower case name of the ssh program at path,
+// without any directory or ".exe" suffix, such as "ssh" or "plink".
+func sshProgramName(path string) string {
+	if i := strings.LastIndexAny(path, `/\`); i != -1 {
+		path = path[i+1:]
+	}
+	return strings.TrimSuffix(strings.ToLower(path), ".exe")
+}
+
+// shellQuote quotes s for use as a single word in a POSIX shell command line.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+./:@,%") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// sshQuote quotes s, if needed, for use as a value in ssh configuration,
+// such as with the -o option.
+func sshQuote(s string) string {
+	if !strings.ContainsAny(s, " \t") {
+		return s
+	}
+	return `"` + s + `"`
+}
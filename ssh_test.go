@@ -0,0 +1,203 @@
+package vcsstate
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestSSHOptionsCommand(t *testing.T) {
+	for _, tc := range []struct {
+		opts SSHOptions
+		base string
+		want string
+	}{
+		{
+			base: "ssh",
+			want: "ssh -o BatchMode=yes -o StrictHostKeyChecking=yes",
+		},
+		{
+			opts: SSHOptions{NoBatchMode: true},
+			base: "ssh -F /etc/ci/ssh_config",
+			want: "ssh -F /etc/ci/ssh_config -o StrictHostKeyChecking=yes",
+		},
+		{
+			opts: SSHOptions{
+				KnownHostsFile: "/ci/known hosts",
+				IdentityFile:   "/ci/it's a key",
+				ConnectTimeout: 1500 * time.Millisecond,
+			},
+			base: "ssh",
+			want: `ssh -o BatchMode=yes -o StrictHostKeyChecking=yes -o 'UserKnownHostsFile="/ci/known hosts"' -i '/ci/it'\''s a key' -o IdentitiesOnly=yes -o ConnectTimeout=2`,
+		},
+	} {
+		if got := tc.opts.command(tc.base); got != tc.want {
+			t.Errorf("%+v: got %q, want %q", tc.opts, got, tc.want)
+		}
+	}
+}
+
+func TestGitSSHCommand(t *testing.T) {
+	const opts = " -o BatchMode=yes -o StrictHostKeyChecking=yes"
+	for _, tc := range []struct {
+		env  []string
+		want string
+	}{
+		{
+			want: "ssh" + opts,
+		},
+		{
+			env:  []string{"GIT_SSH_COMMAND=ssh -F /etc/ci/ssh_config"},
+			want: "ssh -F /etc/ci/ssh_config" + opts,
+		},
+		{
+			env:  []string{"GIT_SSH_COMMAND='C:/Program Files/PuTTY/plink.exe' -batch"},
+			want: "'C:/Program Files/PuTTY/plink.exe' -batch",
+		},
+		{
+			env:  []string{"GIT_SSH_COMMAND=my-plink-wrapper", "GIT_SSH_VARIANT=plink"},
+			want: "my-plink-wrapper",
+		},
+		{
+			env:  []string{"GIT_SSH=/usr/bin/ssh"},
+			want: "/usr/bin/ssh" + opts,
+		},
+		{
+			env:  []string{`GIT_SSH=C:\Program Files\TortoiseGit\bin\TortoisePlink.exe`},
+			want: "",
+		},
+		{
+			env:  []string{"GIT_SSH=/ci/ssh-wrapper"},
+			want: "",
+		},
+		{
+			env:  []string{"GIT_SSH=/ci/ssh-wrapper", "GIT_SSH_VARIANT=ssh"},
+			want: "/ci/ssh-wrapper" + opts,
+		},
+	} {
+		// In safe mode, core.sshCommand isn't read, so git doesn't need to run.
+		g := git{safe: true}
+		if got := g.sshCommand("", tc.env); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"/usr/bin/ssh", "/usr/bin/ssh"},
+		{"", "''"},
+		{"/path/with space", "'/path/with space'"},
+		{"$(touch pwned)", "'$(touch pwned)'"},
+		{"it's", `'it'\''s'`},
+	} {
+		if got := shellQuote(tc.in); got != tc.want {
+			t.Errorf("shellQuote(%q): got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// fakeSSH writes a fake ssh command that records its arguments, one per line,
+// and fails to authenticate. It returns the path of the command and of the record.
+func fakeSSH(t *testing.T, dir string) (path, args string) {
+	t.Helper()
+	path, args = filepath.Join(dir, "ssh"), filepath.Join(dir, "args")
+	writeScript(t, path, `for arg; do echo "$arg"; done > `+args+`
+echo "git@example.com: Permission denied (publickey)." >&2
+exit 255
+`)
+	return path, args
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestGitSSH(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ssh is a shell script")
+	}
+	t.Setenv("GIT_SSH_COMMAND", "")
+	t.Setenv("GIT_SSH", "")
+	const remoteURL = "ssh://git@example.com/repo"
+
+	t.Run("options", func(t *testing.T) {
+		ssh, args := fakeSSH(t, t.TempDir())
+		rv, err := NewRemoteVCS(vcs.ByCmd("git"), SSH(SSHOptions{
+			Binary:         ssh,
+			KnownHostsFile: "/ci/known_hosts",
+			IdentityFile:   "/ci/id_ed25519",
+			ConnectTimeout: 10 * time.Second,
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = rv.RemoteBranchAndRevision(remoteURL)
+		if _, ok := err.(AuthenticationError); !ok {
+			t.Errorf("got error %v, want AuthenticationError", err)
+		}
+		got := readLines(t, args)
+		for _, want := range []string{"BatchMode=yes", "StrictHostKeyChecking=yes", "UserKnownHostsFile=/ci/known_hosts", "/ci/id_ed25519", "ConnectTimeout=10", "git@example.com"} {
+			if !containsString(got, want) {
+				t.Errorf("got ssh args %q, want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("core.sshCommand", func(t *testing.T) {
+		configured, configuredArgs := fakeSSH(t, t.TempDir())
+		dir := tempGitRepo(t)
+		gitRun(t, dir, "remote", "add", "origin", remoteURL)
+		gitRun(t, dir, "config", "core.sshCommand", configured+" -o User=configured")
+
+		v, err := NewVCS(vcs.ByCmd("git"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := v.RemoteBranchAndRevision(dir); err == nil {
+			t.Error("got no error from fake ssh")
+		}
+		got := readLines(t, configuredArgs)
+		for _, want := range []string{"User=configured", "BatchMode=yes", "StrictHostKeyChecking=yes"} {
+			if !containsString(got, want) {
+				t.Errorf("got ssh args %q, want %q", got, want)
+			}
+		}
+
+		// In safe mode, the repository's core.sshCommand isn't used.
+		if err := os.Remove(configuredArgs); err != nil {
+			t.Fatal(err)
+		}
+		bin := t.TempDir()
+		_, defaultArgs := fakeSSH(t, bin)
+		t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+		v, err = NewVCS(vcs.ByCmd("git"), SafeMode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := v.RemoteBranchAndRevision(dir); err == nil {
+			t.Error("got no error from fake ssh")
+		}
+		if _, err := os.Stat(configuredArgs); !os.IsNotExist(err) {
+			t.Error("core.sshCommand was used in safe mode")
+		}
+		if got := readLines(t, defaultArgs); !containsString(got, "BatchMode=yes") {
+			t.Errorf("got ssh args %q, want BatchMode=yes", got)
+		}
+	})
+}
@@ -0,0 +1,396 @@
+package vcsstate
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/shurcooL/go/osutil"
+)
+
+var _, svnBinaryError = exec.LookPath("svn")
+
+// svnCommand returns a command that runs svn with args in dir.
+// It never prompts for input, such as credentials.
+func svnCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("svn", append([]string{"--non-interactive"}, args...)...)
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+	return cmd
+}
+
+type svn struct{}
+
+func (svn) Info(dir string) (Info, error) {
+	return Info{}, nil
+}
+
+func (svn) Status(dir string) (string, error) {
+	cmd := svnCommand(dir, "status", "--xml")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	files, err := parseSvnStatus(out)
+	if err != nil {
+		return "", err
+	}
+	// Format the status the same way as hg status.
+	var buf bytes.Buffer
+	for _, f := range files {
+		fmt.Fprintf(&buf, "%s %s\n", f.Status, f.Path)
+	}
+	return buf.String(), nil
+}
+
+// Branch returns the branch inferred from the working copy URL,
+// assuming the conventional trunk, branches and tags layout.
+func (svn) Branch(dir string) (string, error) {
+	info, err := svnInfoOf(dir, ".")
+	if err != nil {
+		return "", err
+	}
+	return parseSvnBranch(info.path()), nil
+}
+
+func (svn) Operations(dir string) ([]Operation, error) {
+	return nil, errors.New("not implemented for svn")
+}
+
+// LocalRevision returns the revision the checked out branch was last changed in,
+// as of the working copy revision, so that it can be compared to the one returned
+// by RemoteBranchAndRevision. A working copy only has a single branch checked out,
+// so defaultBranch is not used.
+func (svn) LocalRevision(dir string, defaultBranch string) (string, error) {
+	info, err := svnInfoOf(dir, ".")
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(info.Commit.Revision), nil
+}
+
+func (svn) Branches(dir string, defaultBranch string) ([]LocalBranch, error) {
+	return nil, errors.New("not implemented for svn")
+}
+
+// UnpushedCommits always returns no commits, since svn commits
+// are made directly to the remote repository.
+func (svn) UnpushedCommits(dir string, limit int) ([]Commit, error) {
+	return nil, nil
+}
+
+// Stash always returns an empty string, since svn has no stash.
+// Its experimental shelving feature isn't supported.
+func (svn) Stash(dir string) (string, error) {
+	return "", nil
+}
+
+// StashEntries always returns no entries, since svn has no stash.
+func (svn) StashEntries(dir string) ([]StashEntry, error) {
+	return nil, nil
+}
+
+func (svn) Submodules(dir string) ([]Submodule, error) {
+	return nil, errors.New("not implemented for svn")
+}
+
+// Contains reports whether defaultBranch contains revision, and the working copy
+// is updated to include it. The branch history is queried from the remote repository.
+func (svn) Contains(dir string, revision string, defaultBranch string) (bool, error) {
+	n, err := parseSvnRevision(revision)
+	if err != nil {
+		return false, err
+	}
+	info, err := svnInfoOf(dir, ".")
+	if err != nil {
+		return false, err
+	}
+	if n > info.Revision {
+		return false, nil
+	}
+	return svnLogContains(dir, svnBranchURL(info.URL, defaultBranch)+"@"+strconv.Itoa(info.Revision), n)
+}
+
+// RemoteContains reports whether defaultBranch in the remote repository contains revision.
+func (svn) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
+	n, err := parseSvnRevision(revision)
+	if err != nil {
+		return false, err
+	}
+	info, err := svnInfoOf(dir, ".")
+	if err != nil {
+		return false, err
+	}
+	return svnLogContains(dir, svnBranchURL(info.URL, defaultBranch)+"@HEAD", n)
+}
+
+// RemoteURL returns the repository root URL.
+func (svn) RemoteURL(dir string) (string, error) {
+	info, err := svnInfoOf(dir, ".")
+	if err != nil {
+		return "", err
+	}
+	return info.Root, nil
+}
+
+// RemoteBranchAndRevision returns the trunk of the project the working copy
+// belongs to, and the revision it was last changed in. See remoteSvn.RemoteBranchAndRevision.
+func (svn) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
+	info, err := svnInfoOf(dir, ".")
+	if err != nil {
+		return "", "", err
+	}
+	return svnTrunkRevision(info)
+}
+
+func (svn) CachedRemoteDefaultBranch() (string, error) {
+	return "", fmt.Errorf("not implemented for svn, just use NoRemoteDefaultBranch")
+}
+
+func (svn) NoRemoteDefaultBranch() string {
+	return "trunk"
+}
+
+type remoteSvn struct{}
+
+// RemoteBranchAndRevision returns the trunk of the project at remoteURL, which can be
+// a repository root or a URL within a project, and the revision it was last changed in.
+// If the repository doesn't follow the conventional layout and has no trunk,
+// the repository root is used instead.
+func (remoteSvn) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
+	if err := checkRemoteURL(remoteURL, nil); err != nil {
+		return "", "", err
+	}
+	info, err := svnInfoOf("", remoteURL)
+	if err != nil {
+		return "", "", err
+	}
+	return svnTrunkRevision(info)
+}
+
+// svnTrunkRevision returns "trunk" and the revision it was last changed in,
+// for the trunk of the project that info, an entry of a working copy or URL, is in.
+// A project may be in a subdirectory of the repository, like "repo/project/trunk".
+// If there's no trunk, "" and the revision the repository root was last changed in
+// are returned.
+func svnTrunkRevision(info svnEntry) (branch string, revision string, err error) {
+	trunkURL := svnBranchURL(info.URL, "trunk")
+	if !strings.HasSuffix(trunkURL, "/trunk") {
+		// Not in a conventional layout, but the repository root may have a trunk.
+		trunkURL = info.Root + "/trunk"
+	}
+	trunk, err := svnInfoOf("", trunkURL)
+	switch {
+	case err == nil:
+		return "trunk", strconv.Itoa(trunk.Commit.Revision), nil
+	case strings.Contains(err.Error(), "W170000"): // URL non-existent in revision.
+		root, err := svnInfoOf("", info.Root)
+		if err != nil {
+			return "", "", err
+		}
+		return "", strconv.Itoa(root.Commit.Revision), nil
+	default:
+		return "", "", err
+	}
+}
+
+// svnInfoOf runs svn info on target, a working copy path or URL, from dir.
+// It returns NotFoundError if target is a URL of a repository that doesn't exist.
+func svnInfoOf(dir, target string) (svnEntry, error) {
+	cmd := svnCommand(dir, "info", "--xml", "--", target)
+
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err != nil && (bytes.Contains(stderr, []byte("E170013")) || // Unable to connect to a repository.
+		bytes.Contains(stderr, []byte("E180001"))): // Unable to open repository.
+		return svnEntry{}, NotFoundError{Err: fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))}
+	case err != nil:
+		return svnEntry{}, fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	return parseSvnInfo(stdout)
+}
+
+// svnLogContains reports whether the log of target includes revision n.
+func svnLogContains(dir, target string, n int) (bool, error) {
+	cmd := svnCommand(dir, "log", "--xml", "--revision", strconv.Itoa(n), "--", target)
+
+	stdout, stderr, err := dividedOutput(cmd)
+	switch {
+	case err == nil:
+		revs, err := parseSvnLog(stdout)
+		if err != nil {
+			return false, err
+		}
+		return len(revs) != 0, nil // Non-empty log means this commit is indeed contained.
+	case bytes.Contains(stderr, []byte("E160006")), // No such revision.
+		bytes.Contains(stderr, []byte("E160013")), // File not found.
+		bytes.Contains(stderr, []byte("E195012")): // Unable to find repository location.
+		return false, nil
+	default:
+		return false, fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+}
+
+// parseSvnRevision parses an svn revision number, optionally prefixed with "r".
+func parseSvnRevision(revision string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(revision, "r"))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid svn revision %q", revision)
+	}
+	return n, nil
+}
+
+// svnEntry is an entry reported by svn info --xml.
+type svnEntry struct {
+	Revision int    `xml:"revision,attr"`
+	URL      string `xml:"url"`
+	Root     string `xml:"repository>root"`
+	Commit   struct {
+		Revision int `xml:"revision,attr"`
+	} `xml:"commit"`
+}
+
+// path returns the unescaped path of the entry relative to the repository root.
+func (e svnEntry) path() string {
+	p := strings.TrimPrefix(e.URL, e.Root)
+	if u, err := url.PathUnescape(p); err == nil {
+		p = u
+	}
+	return p
+}
+
+func parseSvnInfo(out []byte) (svnEntry, error) {
+	var info struct {
+		Entries []svnEntry `xml:"entry"`
+	}
+	if err := xml.Unmarshal(out, &info); err != nil {
+		return svnEntry{}, err
+	}
+	if len(info.Entries) != 1 {
+		return svnEntry{}, fmt.Errorf("unexpected svn info output: %q", out)
+	}
+	return info.Entries[0], nil
+}
+
+// svnStatusEntry is a file with outstanding status, as reported by svn status --xml.
+type svnStatusEntry struct {
+	Path   string
+	Status string // Status code, as printed by plain svn status.
+}
+
+// svnStatusCodes maps svn status --xml item values to status codes.
+var svnStatusCodes = map[string]string{
+	"added":       "A",
+	"conflicted":  "C",
+	"deleted":     "D",
+	"external":    "X",
+	"ignored":     "I",
+	"incomplete":  "!",
+	"missing":     "!",
+	"modified":    "M",
+	"obstructed":  "~",
+	"replaced":    "R",
+	"unversioned": "?",
+}
+
+func parseSvnStatus(out []byte) ([]svnStatusEntry, error) {
+	type entry struct {
+		Path   string `xml:"path,attr"`
+		Status struct {
+			Item  string `xml:"item,attr"`
+			Props string `xml:"props,attr"`
+		} `xml:"wc-status"`
+	}
+	var status struct {
+		Targets []struct {
+			Entries []entry `xml:"entry"`
+		} `xml:"target"`
+		Changelists []struct {
+			Entries []entry `xml:"entry"`
+		} `xml:"changelist"`
+	}
+	if err := xml.Unmarshal(out, &status); err != nil {
+		return nil, err
+	}
+	var entries []entry
+	for _, t := range status.Targets {
+		entries = append(entries, t.Entries...)
+	}
+	for _, c := range status.Changelists {
+		entries = append(entries, c.Entries...)
+	}
+	var files []svnStatusEntry
+	for _, e := range entries {
+		code, ok := svnStatusCodes[e.Status.Item]
+		switch {
+		case ok:
+		case e.Status.Props == "modified" || e.Status.Props == "conflicted":
+			code = "M" // Only properties have changed.
+		default:
+			continue
+		}
+		files = append(files, svnStatusEntry{Path: e.Path, Status: code})
+	}
+	return files, nil
+}
+
+// parseSvnLog parses svn log --xml output, and returns the revisions of its entries.
+func parseSvnLog(out []byte) ([]int, error) {
+	var log struct {
+		Entries []struct {
+			Revision int `xml:"revision,attr"`
+		} `xml:"logentry"`
+	}
+	if err := xml.Unmarshal(out, &log); err != nil {
+		return nil, err
+	}
+	var revs []int
+	for _, e := range log.Entries {
+		revs = append(revs, e.Revision)
+	}
+	return revs, nil
+}
+
+// parseSvnBranch infers the branch name from path, the path of a working copy
+// relative to the repository root, assuming the conventional trunk, branches and tags
+// layout, possibly nested in a project directory. If path doesn't follow that layout,
+// it's returned without the leading slash.
+func parseSvnBranch(path string) string {
+	elems := strings.Split(strings.Trim(path, "/"), "/")
+	for i, e := range elems {
+		switch {
+		case e == "trunk":
+			return "trunk"
+		case (e == "branches" || e == "tags") && i+1 < len(elems):
+			return elems[i+1]
+		}
+	}
+	return strings.Trim(path, "/")
+}
+
+// svnBranchURL returns the URL of branch, given the URL of a working copy
+// that follows the conventional trunk, branches and tags layout.
+// If it doesn't follow that layout, wcURL is returned unmodified.
+func svnBranchURL(wcURL, branch string) string {
+	elems := strings.Split(wcURL, "/")
+	for i, e := range elems {
+		if e != "trunk" && !((e == "branches" || e == "tags") && i+1 < len(elems)) {
+			continue
+		}
+		project := strings.Join(elems[:i], "/")
+		if branch == "trunk" {
+			return project + "/trunk"
+		}
+		return project + "/branches/" + url.PathEscape(branch)
+	}
+	return wcURL
+}
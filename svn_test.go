@@ -0,0 +1,272 @@
+package vcsstate
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSvnInfo(t *testing.T) {
+	// svn info --xml
+	in := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<info>
+<entry
+   kind="dir"
+   path="."
+   revision="3">
+<url>file:///tmp/repo/project/branches/my%20feature</url>
+<relative-url>^/project/branches/my%20feature</relative-url>
+<repository>
+<root>file:///tmp/repo</root>
+<uuid>0a9d0a6c-9b66-4d1f-8a1b-3d0c5e2b4c1a</uuid>
+</repository>
+<wc-info>
+<wcroot-abspath>/tmp/wc</wcroot-abspath>
+<schedule>normal</schedule>
+<depth>infinity</depth>
+</wc-info>
+<commit
+   revision="2">
+<author>gopher</author>
+<date>2017-07-14T02:40:00.000000Z</date>
+</commit>
+</entry>
+</info>
+`)
+	got, err := parseSvnInfo(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Revision != 3 || got.Commit.Revision != 2 || got.URL != "file:///tmp/repo/project/branches/my%20feature" || got.Root != "file:///tmp/repo" {
+		t.Errorf("got %+v", got)
+	}
+	if got, want := got.path(), "/project/branches/my feature"; got != want {
+		t.Errorf("got path %q, want %q", got, want)
+	}
+}
+
+func TestParseSvnStatus(t *testing.T) {
+	// svn status --xml
+	in := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<status>
+<target
+   path=".">
+<entry
+   path="new.txt">
+<wc-status
+   props="none"
+   item="unversioned">
+</wc-status>
+</entry>
+<entry
+   path="file.txt">
+<wc-status
+   props="none"
+   item="modified"
+   revision="3">
+<commit
+   revision="2">
+<author>gopher</author>
+<date>2017-07-14T02:40:00.000000Z</date>
+</commit>
+</wc-status>
+</entry>
+<entry
+   path="dir">
+<wc-status
+   props="modified"
+   item="normal"
+   revision="3">
+</wc-status>
+</entry>
+</target>
+<changelist
+   name="later">
+<entry
+   path="added.txt">
+<wc-status
+   props="none"
+   item="added"
+   revision="-1">
+</wc-status>
+</entry>
+</changelist>
+</status>
+`)
+	want := []svnStatusEntry{
+		{Path: "new.txt", Status: "?"},
+		{Path: "file.txt", Status: "M"},
+		{Path: "dir", Status: "M"},
+		{Path: "added.txt", Status: "A"},
+	}
+	got, err := parseSvnStatus(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseSvnLog(t *testing.T) {
+	// svn log --xml --revision 2 file:///tmp/repo/trunk
+	in := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<log>
+<logentry
+   revision="2">
+<author>gopher</author>
+<date>2017-07-14T02:40:00.000000Z</date>
+<msg>Add file.</msg>
+</logentry>
+</log>
+`)
+	got, err := parseSvnLog(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseSvnBranch(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/trunk", "trunk"},
+		{"/trunk/sub/dir", "trunk"},
+		{"/branches/feature", "feature"},
+		{"/project/branches/feature/sub", "feature"},
+		{"/tags/v1.0", "v1.0"},
+		{"/branches", "branches"},
+		{"", ""},
+		{"/custom/layout", "custom/layout"},
+	}
+	for _, tc := range tests {
+		if got := parseSvnBranch(tc.in); got != tc.want {
+			t.Errorf("parseSvnBranch(%q): got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestSvnBranchURL(t *testing.T) {
+	tests := []struct {
+		wcURL  string
+		branch string
+		want   string
+	}{
+		{"file:///tmp/repo/trunk", "trunk", "file:///tmp/repo/trunk"},
+		{"file:///tmp/repo/branches/feature/sub", "trunk", "file:///tmp/repo/trunk"},
+		{"https://svn.example.com/repo/project/trunk", "stable", "https://svn.example.com/repo/project/branches/stable"},
+		{"file:///tmp/repo/custom", "trunk", "file:///tmp/repo/custom"},
+	}
+	for _, tc := range tests {
+		if got := svnBranchURL(tc.wcURL, tc.branch); got != tc.want {
+			t.Errorf("svnBranchURL(%q, %q): got %q, want %q", tc.wcURL, tc.branch, got, tc.want)
+		}
+	}
+}
+
+func TestSvn(t *testing.T) {
+	if _, err := exec.LookPath("svnadmin"); err != nil {
+		t.Skip("svnadmin binary not available")
+	}
+	if _, err := exec.LookPath("svn"); err != nil {
+		t.Skip("svn binary not available")
+	}
+	tmp := t.TempDir()
+	repo := filepath.Join(tmp, "repo")
+	wc := filepath.Join(tmp, "wc")
+	if out, err := exec.Command("svnadmin", "create", repo).CombinedOutput(); err != nil {
+		t.Fatalf("svnadmin create: %v: %s", err, out)
+	}
+	repoURL := "file://" + filepath.ToSlash(repo)
+	svnRun(t, tmp, "mkdir", "-q", "-m", "layout", repoURL+"/trunk", repoURL+"/branches")
+	svnRun(t, tmp, "checkout", "-q", repoURL+"/trunk", wc)
+	writeFile(t, filepath.Join(wc, "file"), "initial\n")
+	svnRun(t, wc, "add", "-q", "file")
+	svnRun(t, wc, "commit", "-q", "-m", "initial")
+	svnRun(t, wc, "update", "-q")
+
+	var s VCS = svn{}
+	if got, err := s.Branch(wc); err != nil || got != "trunk" {
+		t.Errorf("Branch: got %q, %v, want trunk", got, err)
+	}
+	if got, err := s.LocalRevision(wc, "trunk"); err != nil || got != "2" {
+		t.Errorf("LocalRevision: got %q, %v, want 2", got, err)
+	}
+	if got, err := s.RemoteURL(wc); err != nil || got != repoURL {
+		t.Errorf("RemoteURL: got %q, %v, want %q", got, err, repoURL)
+	}
+	if got, err := s.Status(wc); err != nil || got != "" {
+		t.Errorf("Status: got %q, %v, want clean", got, err)
+	}
+	if got, err := s.Stash(wc); err != nil || got != "" {
+		t.Errorf("Stash: got %q, %v, want empty", got, err)
+	}
+	writeFile(t, filepath.Join(wc, "file"), "changed\n")
+	if got, err := s.Status(wc); err != nil || got != "M file\n" {
+		t.Errorf("Status: got %q, %v, want modified file", got, err)
+	}
+	svnRun(t, wc, "mkdir", "-q", "-m", "feature", repoURL+"/branches/feature")
+	if got, err := s.Contains(wc, "3", "trunk"); err != nil || got {
+		t.Errorf("Contains(3) before update: got %v, %v, want false", got, err)
+	}
+	svnRun(t, wc, "update", "-q")
+	// The working copy is at r3 now, but trunk was last changed in r2.
+	if got, err := s.LocalRevision(wc, "trunk"); err != nil || got != "2" {
+		t.Errorf("LocalRevision after update: got %q, %v, want 2", got, err)
+	}
+	for _, tc := range []struct {
+		revision string
+		want     bool
+	}{{"1", true}, {"2", true}, {"r2", true}, {"3", false}} {
+		if got, err := s.Contains(wc, tc.revision, "trunk"); err != nil || got != tc.want {
+			t.Errorf("Contains(%q): got %v, %v, want %v", tc.revision, got, err, tc.want)
+		}
+	}
+	branch, revision, err := s.RemoteBranchAndRevision(wc)
+	if err != nil || branch != "trunk" || revision != "2" {
+		t.Errorf("RemoteBranchAndRevision: got %q, %q, %v, want trunk, 2", branch, revision, err)
+	}
+
+	// A project in a subdirectory of the repository has its own trunk.
+	project := filepath.Join(tmp, "project")
+	svnRun(t, tmp, "mkdir", "-q", "--parents", "-m", "project", repoURL+"/project/trunk")
+	svnRun(t, tmp, "checkout", "-q", repoURL+"/project/trunk", project)
+	branch, revision, err = s.RemoteBranchAndRevision(project)
+	if err != nil || branch != "trunk" || revision != "4" {
+		t.Errorf("RemoteBranchAndRevision of project: got %q, %q, %v, want trunk, 4", branch, revision, err)
+	}
+	if got, err := s.LocalRevision(project, "trunk"); err != nil || got != revision {
+		t.Errorf("LocalRevision of project: got %q, %v, want %q", got, err, revision)
+	}
+
+	_, _, err = remoteSvn{}.RemoteBranchAndRevision(repoURL + "-missing")
+	if _, ok := err.(NotFoundError); !ok {
+		t.Errorf("RemoteBranchAndRevision of missing repository: got %v, want NotFoundError", err)
+	}
+}
+
+func TestSvnRemoteURLArgs(t *testing.T) {
+	for _, remoteURL := range []string{"--config-dir=/tmp/evil", "-rHEAD", ""} {
+		if _, _, err := (remoteSvn{}).RemoteBranchAndRevision(remoteURL); err == nil || !strings.Contains(err.Error(), "invalid remote URL") {
+			t.Errorf("RemoteBranchAndRevision(%q): got %v, want invalid remote URL error", remoteURL, err)
+		}
+	}
+}
+
+// svnRun runs svn with args in dir, and returns its output.
+func svnRun(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("svn", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("svn %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
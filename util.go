@@ -13,3 +13,15 @@ func dividedOutput(cmd *exec.Cmd) (stdout []byte, stderr []byte, err error) {
 	err = cmd.Run()
 	return outb.Bytes(), errb.Bytes(), err
 }
+
+// splitNUL splits NUL-terminated output, such as produced by git's -z option, into fields.
+func splitNUL(out []byte) []string {
+	var fields []string
+	for _, f := range bytes.Split(out, []byte{0}) {
+		if len(f) == 0 {
+			continue
+		}
+		fields = append(fields, string(f))
+	}
+	return fields
+}
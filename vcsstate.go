@@ -2,16 +2,33 @@
 package vcsstate
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/shurcooL/vcsstate/remoteurl"
 	"golang.org/x/tools/go/vcs"
 )
 
 // ErrNoRemote is the error used when the local repository doesn't have a valid remote.
 var ErrNoRemote = errors.New("local repository has no valid remote")
 
+// ErrNoWorkingTree is the error used when querying working directory state
+// of a repository that has no working tree, such as a bare repository.
+var ErrNoWorkingTree = errors.New("repository has no working tree")
+
+// ErrHistoryTruncated is the error used when a query can't be answered conclusively
+// because the local repository has incomplete history, such as a shallow clone.
+var ErrHistoryTruncated = errors.New("unknown: history truncated")
+
+// ErrShelveNotEnabled is the error used when the Mercurial shelve extension,
+// which provides stash functionality for hg, is not enabled.
+var ErrShelveNotEnabled = errors.New("shelve extension not enabled")
+
+// ErrSafeModeNotSupported is the error used when safe mode is requested for
+// a version control system, or a version of its binary, that doesn't support it.
+var ErrSafeModeNotSupported = errors.New("safe mode not supported")
+
 // NotFoundError records an error where the remote repository is not found.
 type NotFoundError struct {
 	Err error // Underlying error with more details.
@@ -21,28 +38,82 @@ func (e NotFoundError) Error() string {
 	return fmt.Sprintf("remote repository not found:\n%v", e.Err)
 }
 
+// AuthenticationError records an error where authenticating with the remote
+// repository failed, or would have required an interactive prompt.
+type AuthenticationError struct {
+	Err error // Underlying error with more details.
+}
+
+func (e AuthenticationError) Error() string {
+	return fmt.Sprintf("remote authentication failed:\n%v", e.Err)
+}
+
+// UnsafeRepositoryError records an error where git refused to use a repository
+// owned by someone else, because it's not allowed by the safe.directory configuration.
+type UnsafeRepositoryError struct {
+	Dir string // Repository directory, as reported by git.
+	Err error  // Underlying error with more details.
+}
+
+func (e UnsafeRepositoryError) Error() string {
+	return fmt.Sprintf("unsafe repository %q is owned by someone else:\n%v", e.Dir, e.Err)
+}
+
 // VCS describes how to use a version control system to get the status of a repository
 // rooted at dir.
 type VCS interface {
+	// Info returns information about the layout of the repository at dir.
+	Info(dir string) (Info, error)
+
 	// Status returns the status of working directory.
 	// It returns empty string if no outstanding status.
+	// Status, Stash, StashEntries and Submodules return ErrNoWorkingTree
+	// if the repository has no working tree.
 	Status(dir string) (string, error)
 
 	// Branch returns the name of the locally checked out branch.
 	Branch(dir string) (string, error)
 
+	// Operations returns the operations, such as a merge or rebase,
+	// that are in progress in the working directory.
+	Operations(dir string) ([]Operation, error)
+
 	// LocalRevision returns current local revision of default branch.
 	LocalRevision(dir string, defaultBranch string) (string, error)
 
+	// Branches returns all local branches, and whether each of them
+	// is fully merged into the local default branch.
+	Branches(dir string, defaultBranch string) ([]LocalBranch, error)
+
+	// UnpushedCommits returns local commits, on any branch, that have not been pushed
+	// to any remote, newest first. If limit is positive, at most limit commits,
+	// the most recent ones, are returned.
+	UnpushedCommits(dir string, limit int) ([]Commit, error)
+
 	// Stash returns a non-empty string if the repository has a stash.
+	// For git, the stash is shared by all worktrees of a repository.
 	Stash(dir string) (string, error)
 
+	// StashEntries returns the entries of the stash, most recent first.
+	// For git, the stash is shared by all worktrees of a repository.
+	// For hg, ErrShelveNotEnabled is returned if the shelve extension is not enabled.
+	StashEntries(dir string) ([]StashEntry, error)
+
+	// Submodules returns the git submodules or Mercurial subrepositories
+	// of the repository at dir. Nested submodules are only included with
+	// Options.RecurseSubmodules; otherwise, they can be queried by calling
+	// Submodules on a submodule path.
+	Submodules(dir string) ([]Submodule, error)
+
 	// Contains reports whether the local default branch contains
-	// the commit specified by revision.
+	// the commit specified by revision. In a shallow repository, where
+	// a commit may not be found because of missing history,
+	// ErrHistoryTruncated is returned instead of false.
 	Contains(dir string, revision string, defaultBranch string) (bool, error)
 
 	// RemoteContains reports whether the remote default branch contains
-	// the commit specified by revision.
+	// the commit specified by revision. Like Contains, it returns
+	// ErrHistoryTruncated instead of false in a shallow repository.
 	RemoteContains(dir string, revision string, defaultBranch string) (bool, error)
 
 	// RemoteURL returns primary remote URL, as set in the local repository.
@@ -70,30 +141,237 @@ type VCS interface {
 	NoRemoteDefaultBranch() string
 }
 
-// NewVCS creates a VCS with same type as vcs.
-func NewVCS(vcs *vcs.Cmd) (VCS, error) {
-	switch vcs.Cmd {
-	case "git":
-		if gitBinaryError != nil {
-			return nil, gitBinaryError
-		}
-		var major, minor int
-		_, err := fmt.Fscanf(bytes.NewReader(gitBinaryVersion), "git version %d.%d", &major, &minor)
-		if err != nil {
-			return nil, err
-		}
-		if major > 2 || major == 2 && minor >= 8 {
-			return git28{}, nil
-		} else if major > 1 || major == 1 && minor >= 7 {
-			return git17{}, nil
-		} else {
-			return nil, fmt.Errorf("git support requires git binary version 1.7+, but you have: %q", gitBinaryVersion)
-		}
-	case "hg":
-		return hg{}, hgBinaryError
-	default:
+// WorktreeLister is implemented by a VCS that supports multiple working trees
+// per repository, which is only git. It can be obtained with a type assertion.
+type WorktreeLister interface {
+	// Worktrees returns all working trees of the repository at dir,
+	// starting with the main working tree.
+	Worktrees(dir string) ([]Worktree, error)
+}
+
+// MirrorStatusReporter is implemented by a VCS that supports mirror repositories,
+// which is only git. It can be obtained with a type assertion.
+type MirrorStatusReporter interface {
+	// MirrorStatus reports how up to date the mirror repository at dir,
+	// as created with git clone --mirror, is with its remote.
+	// This operation requires the use of network, and will fail if offline.
+	MirrorStatus(dir string) (MirrorStatus, error)
+}
+
+// HeadsReporter is implemented by a VCS where a branch can have multiple heads,
+// which is only hg. It can be obtained with a type assertion.
+type HeadsReporter interface {
+	// Heads returns the heads of all branches, and whether the working directory
+	// needs a merge.
+	Heads(dir string) (Heads, error)
+}
+
+// EvolutionReporter is implemented by a VCS with changeset phases and evolution,
+// which is only hg. It can be obtained with a type assertion.
+type EvolutionReporter interface {
+	// Evolution returns the phase of the working directory parent, and counts of
+	// non-public and unstable changesets.
+	Evolution(dir string) (Evolution, error)
+}
+
+// URLRewriter is implemented by a VCS whose configuration can rewrite remote URLs,
+// which is only git. It can be obtained with a type assertion.
+type URLRewriter interface {
+	// URLRewrites returns the url.<base>.insteadOf rules that apply
+	// to the repository at dir, from all of its configuration files.
+	URLRewrites(dir string) (remoteurl.Rewrites, error)
+}
+
+// Info describes the layout of a local repository.
+type Info struct {
+	// Bare is true when the repository has no working tree.
+	// Mirror is true when it's a mirror of its remote, as created
+	// with git clone --mirror. Mirrors are always bare.
+	Bare   bool
+	Mirror bool
+
+	// Shallow is true when the repository has truncated history,
+	// as created with git clone --depth.
+	Shallow bool
+
+	// Partial is true when the repository may be missing objects that
+	// will be fetched on demand from a promisor remote, as created with
+	// git clone --filter. Partial clones have complete commit history,
+	// so they don't affect the results of Contains and RemoteContains.
+	Partial bool
+
+	// LinkedWorktree is true when the directory is a linked git worktree,
+	// created with git worktree add, rather than the main working tree.
+	LinkedWorktree bool
+}
+
+// MirrorStatus describes how up to date a mirror repository is with its remote.
+type MirrorStatus struct {
+	LastFetch time.Time // Time of last fetch. Zero if the mirror has never been fetched.
+
+	// Stale lists refs that differ between the mirror and its remote,
+	// including refs that exist only on one side. It's empty if the mirror is up to date.
+	Stale []string
+}
+
+// Worktree describes a working tree of a repository.
+type Worktree struct {
+	Path     string
+	Revision string // Checked out revision. Empty for a bare repository.
+	Branch   string // Checked out branch. Empty if HEAD is detached.
+	Bare     bool   // Worktree is a bare repository, and has no working tree.
+
+	Locked     bool   // Worktree is locked, and can't be pruned.
+	LockReason string // Reason the worktree is locked, if one was given.
+	Prunable   bool   // Worktree directory no longer exists, and it can be pruned.
+
+	Dirty bool // Worktree has outstanding status.
+}
+
+// Operation describes an operation in progress in a working directory.
+type Operation struct {
+	Kind OperationKind
+
+	// Branch is the branch being operated on, e.g., the branch being rebased,
+	// or the branch bisect was started from. It's empty if unknown.
+	Branch string
+
+	// Target is the revision being merged, rebased onto, cherry-picked, etc.
+	// It's empty if unknown.
+	Target string
+
+	// Step is the current step, starting at 1, out of Total steps.
+	// Both are 0 if unknown.
+	Step  int
+	Total int
+}
+
+// OperationKind is the kind of an operation in progress.
+type OperationKind string
+
+// Operation kinds.
+const (
+	OperationMerge      OperationKind = "merge"
+	OperationRebase     OperationKind = "rebase"
+	OperationAm         OperationKind = "am"          // git am.
+	OperationCherryPick OperationKind = "cherry-pick" // git cherry-pick.
+	OperationRevert     OperationKind = "revert"      // git revert.
+	OperationBisect     OperationKind = "bisect"
+	OperationGraft      OperationKind = "graft"    // hg graft.
+	OperationHistedit   OperationKind = "histedit" // hg histedit.
+	OperationUpdate     OperationKind = "update"   // Interrupted hg update.
+)
+
+// Heads describes the heads of branches in a repository.
+type Heads struct {
+	Branches []BranchHeads
+
+	// Parent is the working directory parent, and AtTip is true
+	// when it's the tip-most open head of its branch.
+	Parent string
+	AtTip  bool
+
+	// NeedsMerge is true when the branch of the working directory parent
+	// has more than one open head.
+	NeedsMerge bool
+}
+
+// BranchHeads describes the heads of a single branch.
+type BranchHeads struct {
+	Branch      string
+	Heads       []string // Open heads, tip-most first.
+	ClosedHeads []string // Closed heads, tip-most first.
+}
+
+// Closed reports whether the branch is closed, i.e., all of its heads are closed.
+func (b BranchHeads) Closed() bool {
+	return len(b.Heads) == 0
+}
+
+// Evolution describes changeset phases and evolve instability in a repository.
+// Obsolete and unstable counts are always zero when obsolescence markers are not enabled.
+type Evolution struct {
+	Phase string // Phase of the working directory parent: "public", "draft" or "secret".
+
+	Draft  int // Number of draft changesets.
+	Secret int // Number of secret changesets.
+
+	Obsolete         int // Number of visible obsolete changesets.
+	Orphan           int
+	PhaseDivergent   int
+	ContentDivergent int
+}
+
+// Unstable reports whether there are any orphan or divergent changesets.
+func (e Evolution) Unstable() bool {
+	return e.Orphan != 0 || e.PhaseDivergent != 0 || e.ContentDivergent != 0
+}
+
+// LocalBranch describes a local branch.
+type LocalBranch struct {
+	Name     string    // Branch name.
+	Revision string    // Revision the branch points to.
+	Time     time.Time // Commit time of Revision.
+
+	// Bookmark is true when the branch is a Mercurial bookmark
+	// rather than a named branch. It's always false for git.
+	Bookmark bool
+
+	// Upstream is the configured upstream branch, e.g., "origin/master",
+	// or empty string if there's none. Ahead and Behind report the number
+	// of commits the branch has that upstream doesn't, and vice versa.
+	// Gone is true when upstream is configured, but no longer exists.
+	// Mercurial has no concept of upstream branches, so these are always unset for hg.
+	Upstream string
+	Ahead    int
+	Behind   int
+	Gone     bool
+
+	// Merged is true when the branch is fully merged into the default branch.
+	Merged bool
+}
+
+// Commit describes a single commit.
+type Commit struct {
+	Revision string
+	Author   string    // Author name and email, e.g., "Gopher <gopher@example.com>".
+	Time     time.Time // Commit time.
+	Subject  string    // First line of commit message.
+	Branch   string    // Branch the commit was found on.
+}
+
+// StashEntry describes a single git stash or Mercurial shelve entry.
+type StashEntry struct {
+	Name    string    // Name of entry, e.g., "stash@{0}" for git, or shelve name for hg.
+	Branch  string    // Branch the entry was created on.
+	Message string    // Entry message.
+	Time    time.Time // Creation time.
+	Base    string    // Revision the entry is based on.
+	Files   []string  // Files touched by the entry.
+}
+
+// Submodule describes a git submodule or Mercurial subrepository.
+type Submodule struct {
+	Path string // Path relative to the repository root, with '/' as separator.
+	URL  string // URL as configured in .gitmodules or .hgsub.
+
+	// RecordedRevision is the revision recorded in the parent repository,
+	// and Revision is the revision checked out in the submodule.
+	// Revision is empty if the submodule is not initialized.
+	RecordedRevision string
+	Revision         string
+
+	Initialized bool // Submodule is checked out.
+	Dirty       bool // Submodule has outstanding status.
+}
+
+// NewVCS creates a VCS with same type as vcs, using the factory registered for vcs.Cmd.
+func NewVCS(vcs *vcs.Cmd, opts ...Option) (VCS, error) {
+	f, ok := lookupFactory(vcs.Cmd)
+	if !ok {
 		return nil, fmt.Errorf("%v (%v) support not implemented", vcs.Name, vcs.Cmd)
 	}
+	return f.NewVCS(newOptions(opts))
 }
 
 // RemoteVCS describes how to use a version control system to get the remote status of a repository
@@ -104,28 +382,11 @@ type RemoteVCS interface {
 	RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error)
 }
 
-// NewRemoteVCS creates a RemoteVCS with same type as vcs.
-func NewRemoteVCS(vcs *vcs.Cmd) (RemoteVCS, error) {
-	switch vcs.Cmd {
-	case "git":
-		if gitBinaryError != nil {
-			return nil, gitBinaryError
-		}
-		var major, minor int
-		_, err := fmt.Fscanf(bytes.NewReader(gitBinaryVersion), "git version %d.%d", &major, &minor)
-		if err != nil {
-			return nil, err
-		}
-		if major > 2 || major == 2 && minor >= 8 {
-			return remoteGit28{}, nil
-		} else if major > 1 || major == 1 && minor >= 7 {
-			return remoteGit17{}, nil
-		} else {
-			return nil, fmt.Errorf("remote git support requires git binary version 1.7+, but you have: %q", gitBinaryVersion)
-		}
-	case "hg":
-		return remoteHg{}, hgBinaryError
-	default:
+// NewRemoteVCS creates a RemoteVCS with same type as vcs, using the factory registered for vcs.Cmd.
+func NewRemoteVCS(vcs *vcs.Cmd, opts ...Option) (RemoteVCS, error) {
+	f, ok := lookupFactory(vcs.Cmd)
+	if !ok {
 		return nil, fmt.Errorf("%v (%v) support not implemented", vcs.Name, vcs.Cmd)
 	}
+	return f.NewRemoteVCS(newOptions(opts))
 }